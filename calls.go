@@ -0,0 +1,104 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "strings"
+
+// dependency is a reference of a program unit to another object,
+// as in the *_DEPENDENCIES views.
+type dependency struct {
+	Owner, Name, Type          string
+	RefOwner, RefName, RefType string
+}
+
+// parseDependencies returns the references of the sources to the other sources,
+// found in their code: PKG.proc, OWNER.PKG.proc and standalone calls.
+// A reference is a name of a source, not after a dot (except after its owner).
+func parseDependencies(sources []source) []dependency {
+	known := make(map[string]source, len(sources))
+	byName := make(map[string][]source, len(sources))
+	for _, s := range sources {
+		if _, ok := known[qualifiedName(s.Owner, s.Name)]; !ok {
+			known[qualifiedName(s.Owner, s.Name)] = s
+			byName[s.Name] = append(byName[s.Name], s)
+		}
+	}
+	// resolve returns the referenced source of owner.name (owner may be empty).
+	resolve := func(owner, name, defOwner string) (source, bool) {
+		if owner != "" {
+			s, ok := known[qualifiedName(owner, name)]
+			return s, ok
+		}
+		if s, ok := known[qualifiedName(defOwner, name)]; ok {
+			return s, true
+		}
+		if ss := byName[name]; len(ss) == 1 {
+			return ss[0], true
+		}
+		return source{}, false
+	}
+	var deps []dependency
+	seen := make(map[[2]string]bool)
+	for _, s := range sources {
+		toks := significant(lex(s.Code))
+		for i, t := range toks {
+			if !t.isName() || i > 0 && toks[i-1].is(".") {
+				continue
+			}
+			ref, ok := source{}, false
+			if i+2 < len(toks) && toks[i+1].is(".") && toks[i+2].isName() {
+				ref, ok = resolve(t.name(), toks[i+2].name(), s.Owner)
+			}
+			if !ok {
+				ref, ok = resolve("", t.name(), s.Owner)
+			}
+			if !ok || ref.Owner == s.Owner && ref.Name == s.Name {
+				continue
+			}
+			key := [2]string{qualifiedName(s.Owner, s.Name), qualifiedName(ref.Owner, ref.Name)}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			deps = append(deps, dependency{Owner: s.Owner, Name: s.Name, Type: s.Type,
+				RefOwner: ref.Owner, RefName: ref.Name, RefType: ref.Type})
+		}
+	}
+	return deps
+}
+
+// newCallGraph returns the graph of the dependencies between the program units
+// of the sources (the package specs and bodies are one node),
+// from deps, or parsed from the sources if deps is nil.
+func newCallGraph(sources []source, deps []dependency) *depGraph {
+	if deps == nil {
+		deps = parseDependencies(sources)
+	}
+	var nodes []depNode
+	seen := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		if key := qualifiedName(s.Owner, s.Name); !seen[key] {
+			seen[key] = true
+			nodes = append(nodes, depNode{Owner: s.Owner, Name: s.Name, Kind: strings.TrimSuffix(s.Type, " BODY")})
+		}
+	}
+	edges := make([][2]string, 0, len(deps))
+	for _, d := range deps {
+		edges = append(edges, [2]string{qualifiedName(d.Owner, d.Name), qualifiedName(d.RefOwner, d.RefName)})
+	}
+	return newDepGraph(nodes, edges)
+}
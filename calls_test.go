@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// callSources call each other in a DB_A -> DB_B -> DB_C -> DB_A cycle.
+var callSources = []source{
+	{Name: "DB_A", Type: "PACKAGE", Code: `PACKAGE DB_A IS PROCEDURE z; END DB_A;`},
+	{Name: "DB_A", Type: "PACKAGE BODY", Code: `PACKAGE BODY DB_A IS
+PROCEDURE z IS BEGIN DB_B.x(1); DBMS_OUTPUT.put_line('DB_C'); END z;
+END DB_A;`},
+	{Name: "DB_B", Type: "PACKAGE BODY", Code: `PACKAGE BODY DB_B IS
+PROCEDURE x(p IN NUMBER) IS BEGIN v := db_c.y; END x;
+END DB_B;`},
+	{Name: "DB_C", Type: "PACKAGE BODY", Code: `PACKAGE BODY DB_C IS
+FUNCTION y RETURN NUMBER IS BEGIN DB_A.z; RETURN db_log('y'); END y;
+END DB_C;`},
+	{Name: "DB_D", Type: "PROCEDURE", Code: `PROCEDURE DB_D IS BEGIN DB_A.z; db_log('d'); END;`},
+	{Name: "DB_LOG", Type: "FUNCTION", Code: `FUNCTION DB_LOG(p IN VARCHAR2) RETURN NUMBER IS BEGIN RETURN 0; END;`},
+}
+
+func TestParseDependencies(t *testing.T) {
+	var got []string
+	for _, d := range parseDependencies(callSources) {
+		got = append(got, d.Name+"->"+d.RefName)
+	}
+	if awaited := "DB_A->DB_B DB_B->DB_C DB_C->DB_A DB_C->DB_LOG DB_D->DB_A DB_D->DB_LOG"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", strings.Join(got, " "), awaited)
+	}
+}
+
+func TestCallGraphCycles(t *testing.T) {
+	deps := []dependency{
+		{Name: "DB_A", RefName: "DB_B"}, {Name: "DB_B", RefName: "DB_A"},
+		{Name: "DB_C", RefName: "DB_D"}, {Name: "DB_D", RefName: "DB_E"}, {Name: "DB_E", RefName: "DB_C"},
+		{Name: "DB_B", RefName: "DB_C"}, {Name: "DB_F", RefName: "DB_F"}, {Name: "DB_F", RefName: "SYS_X"},
+	}
+	var sources []source
+	for _, name := range []string{"DB_F", "DB_E", "DB_D", "DB_C", "DB_B", "DB_A"} {
+		sources = append(sources, source{Name: name, Type: "PACKAGE"})
+	}
+	g := newCallGraph(sources, deps)
+	if g.Cycles != 2 {
+		t.Errorf("got %d cycles, awaited 2.", g.Cycles)
+	}
+	var got []string
+	for _, n := range g.Nodes {
+		got = append(got, n.Name+":"+string(rune('0'+n.Cycle)))
+	}
+	if awaited := "DB_A:1 DB_B:1 DB_C:2 DB_D:2 DB_E:2 DB_F:0"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", strings.Join(got, " "), awaited)
+	}
+	for _, e := range g.Edges {
+		from, to := g.Nodes[e.From].Name, g.Nodes[e.To].Name
+		if awaited := !(from == "DB_B" && to == "DB_C"); e.Cycle != awaited {
+			t.Errorf("%s->%s: got cycle %t, awaited %t.", from, to, e.Cycle, awaited)
+		}
+	}
+	if len(g.Edges) != 6 {
+		t.Errorf("got %d edges, awaited 6 (without the self and unknown references).", len(g.Edges))
+	}
+}
+
+func TestCallGraphGolden(t *testing.T) {
+	g := newCallGraph(callSources, nil)
+	for _, format := range rendererNames() {
+		var buf bytes.Buffer
+		if err := renderers[format](renderOptions{}).RenderDeps(&buf, g); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		checkGolden(t, "calls."+format, buf.Bytes())
+	}
+}
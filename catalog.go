@@ -0,0 +1,175 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// catalog resolves the table names found in the code to the known tables.
+type catalog struct {
+	tables map[string]*table   // by qualified name
+	byName map[string][]*table // by (unqualified) name
+}
+
+func newCatalog(tables []table) catalog {
+	c := catalog{
+		tables: make(map[string]*table, len(tables)),
+		byName: make(map[string][]*table, len(tables)),
+	}
+	for i := range tables {
+		t := &tables[i]
+		c.tables[qualifiedName(t.Owner, t.Name)] = t
+		c.byName[t.Name] = append(c.byName[t.Name], t)
+	}
+	return c
+}
+
+// resolve returns the known table for owner.name.
+// Without owner, it is the table of the defOwner (the owner of the code),
+// or the only table with that name in any schema.
+func (c catalog) resolve(owner, name, defOwner string) (*table, bool) {
+	if owner == "" {
+		owner = defOwner
+	}
+	if t, ok := c.tables[qualifiedName(owner, name)]; ok {
+		return t, true
+	}
+	// the owner may be unknown (single schema mode), or the name is a synonym
+	if ts := c.byName[name]; len(ts) == 1 {
+		return ts[0], true
+	}
+	return nil, false
+}
+
+// resolveLink resolves both sides of the link, with the owners filled.
+func (c catalog) resolveLink(lnk link, defOwner string) (link, bool) {
+	sides, ok := c.resolveSides(lnk, defOwner)
+	if !ok {
+		return lnk, false
+	}
+	return newLink(sides[0], sides[1])
+}
+
+// resolveForeignLink resolves the link of a foreign key as resolveLink does,
+// but keeps a self-referencing foreign key as a link from the foreign key
+// to the referenced key of the same table.
+func (c catalog) resolveForeignLink(lnk link, defOwner string) (link, bool) {
+	sides, ok := c.resolveSides(lnk, defOwner)
+	if !ok {
+		return lnk, false
+	}
+	if sides[0].qualified() == sides[1].qualified() {
+		return pairLink(sides[0], sides[1]), true
+	}
+	return newLink(sides[0], sides[1])
+}
+
+// resolveSides returns both sides of the link resolved to the known tables.
+func (c catalog) resolveSides(lnk link, defOwner string) ([2]linkField, bool) {
+	sides := [2]linkField{lnk.A, lnk.B}
+	for i, f := range sides {
+		t, ok := c.resolve(f.Owner, f.Table, defOwner)
+		if !ok {
+			glog.Infof("%q is not a table name.", f.qualified())
+			return sides, false
+		}
+		sides[i].Owner, sides[i].Table = t.Owner, t.Name
+	}
+	return sides, true
+}
+
+// qualify sets the alias of the unqualified columns of the equations of q
+// and its subqueries to the one table of the FROM clause having that column,
+// or to the one table of the enclosing queries if none has.
+// If no table has it, but the columns of the only table of the FROM clause are known,
+// the name is not a column (but a variable, or a function such as SYSDATE),
+// and its equation is dropped.
+// It returns the ambiguous columns, as "COLUMN (A, B)" with the aliases of the tables.
+func (c catalog) qualify(q *query, defOwner string) []string {
+	var ambiguous []string
+	q.walk(func(q *query) {
+		eqs := q.Equations[:0]
+		for _, eq := range q.Equations {
+			keep := true
+			for j, ref := range eq {
+				if ref.Alias != "" {
+					continue
+				}
+				resolved := false
+				for _, tables := range [][]tableRef{q.Tables, q.Outer} {
+					var found []string
+					for _, t := range tables {
+						if c.hasColumn(t, ref.Column, defOwner) {
+							found = append(found, t.Alias)
+						}
+					}
+					if len(found) == 1 {
+						eq[j].Alias = found[0]
+					} else if len(found) > 1 {
+						ambiguous = append(ambiguous, ref.Column+" ("+strings.Join(found, ", ")+")")
+					}
+					if len(found) != 0 {
+						resolved = true
+						break
+					}
+				}
+				if !resolved && len(q.Tables) == 1 && c.knowsColumns(q.Tables[0], defOwner) {
+					glog.V(1).Infof("%s is not a column of %s.", ref.Column, q.Tables[0].Name)
+					keep = false
+				}
+			}
+			if keep {
+				eqs = append(eqs, eq)
+			}
+		}
+		q.Equations = eqs
+	})
+	return ambiguous
+}
+
+// knowsColumns reports whether the referenced table is known, with its columns.
+func (c catalog) knowsColumns(ref tableRef, defOwner string) bool {
+	if ref.Sub != nil {
+		return false
+	}
+	t, ok := c.resolve(ref.Owner, ref.Name, defOwner)
+	return ok && len(t.Fields) != 0
+}
+
+// hasColumn reports whether the referenced table, or the table under
+// the referenced inline view, is known and has the column.
+func (c catalog) hasColumn(ref tableRef, column, defOwner string) bool {
+	if ref.Sub != nil {
+		var ok bool
+		if ref, column, ok = ref.Sub.output(column, 0); !ok {
+			return false
+		}
+	}
+	t, ok := c.resolve(ref.Owner, ref.Name, defOwner)
+	if !ok {
+		return false
+	}
+	for _, f := range t.Fields {
+		if f.Name == column {
+			return true
+		}
+	}
+	return false
+}
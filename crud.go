@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strings"
+)
+
+// crudWriters maps the -crud format names to the CRUD matrix writers.
+var crudWriters = map[string]func(io.Writer, crudMatrix) error{
+	"csv":      writeCRUDCSV,
+	"markdown": writeCRUDMarkdown,
+	"json":     writeCRUDJSON,
+}
+
+// crudWriterNames returns the -crud format names, sorted.
+func crudWriterNames() []string {
+	names := make([]string, 0, len(crudWriters))
+	for k := range crudWriters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// crudMatrix is the program unit × table matrix of the operations
+// (C: insert, R: select, U: update, D: delete).
+type crudMatrix struct {
+	Tables  []string // sorted
+	Sources []crudRow
+}
+
+type crudRow struct {
+	Source string
+	Ops    map[string]string // by table
+}
+
+// newCRUDMatrix returns the matrix of the usage of the tables by the sources.
+func newCRUDMatrix(usage []sourceUsage) crudMatrix {
+	var m crudMatrix
+	for _, su := range usage {
+		row := crudRow{Source: su.Source, Ops: make(map[string]string, len(su.Tables))}
+		for _, tu := range su.Tables {
+			row.Ops[tu.Table] = tu.Ops
+			m.Tables = addString(m.Tables, tu.Table)
+		}
+		m.Sources = append(m.Sources, row)
+	}
+	sort.Strings(m.Tables)
+	return m
+}
+
+func writeCRUDCSV(w io.Writer, m crudMatrix) error {
+	cw := csv.NewWriter(w)
+	record := append([]string{"SOURCE"}, m.Tables...)
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for _, row := range m.Sources {
+		record = append(record[:0], row.Source)
+		for _, t := range m.Tables {
+			record = append(record, row.Ops[t])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCRUDMarkdown(w io.Writer, m crudMatrix) error {
+	bw := bufio.NewWriter(w)
+	escape := strings.NewReplacer("|", `\|`).Replace
+	bw.WriteString("| Source |")
+	for _, t := range m.Tables {
+		bw.WriteString(" " + escape(t) + " |")
+	}
+	bw.WriteString("\n| --- |")
+	for range m.Tables {
+		bw.WriteString(" :-: |")
+	}
+	bw.WriteByte('\n')
+	for _, row := range m.Sources {
+		bw.WriteString("| " + escape(row.Source) + " |")
+		for _, t := range m.Tables {
+			bw.WriteString(" " + row.Ops[t] + " |")
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func writeCRUDJSON(w io.Writer, m crudMatrix) error {
+	return writeJSON(w, m)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// d2FKStyle is the style of the edges of the declared foreign keys.
+const d2FKStyle = "style.stroke: blue; style.stroke-width: 3"
+
+// d2ViewStyle is the style of the views, and the edges from the views to their tables.
+const d2ViewStyle = "style.stroke-dash: 3"
+
+// d2DynamicStyle is the style of the joins found only in dynamic SQL.
+const d2DynamicStyle = "style.stroke-dash: 1"
+
+func init() {
+	renderers["d2"] = func(opts renderOptions) renderer { return d2Renderer{opts} }
+}
+
+// d2Renderer renders the graph as D2 sql_table shapes,
+// in a container per schema if there are more than one.
+type d2Renderer struct {
+	renderOptions
+}
+
+func (r d2Renderer) Render(w io.Writer, g *graph) error {
+	bw := bufio.NewWriter(w)
+	for _, owner := range g.Owners {
+		indent := ""
+		if len(g.Owners) > 1 {
+			fmt.Fprintf(bw, "%s: {\n", d2Key(owner))
+			indent = "  "
+		}
+		tables, orphans := g.ownerTables(owner)
+		for _, t := range tables {
+			r.writeTable(bw, indent, t)
+		}
+		if len(orphans) > 0 {
+			fmt.Fprintf(bw, "%sorphans: {\n", indent)
+			for _, t := range orphans {
+				r.writeTable(bw, indent+"  ", t)
+			}
+			fmt.Fprintf(bw, "%s}\n", indent)
+		}
+		if len(g.Owners) > 1 {
+			bw.WriteString("}\n")
+		}
+	}
+	bw.WriteByte('\n')
+	multi := len(g.Owners) > 1
+	for _, e := range g.Edges {
+		op := "--"
+		if r.Directed || e.Kind == edgeLineage {
+			op = "->"
+		}
+		fmt.Fprintf(bw, "%s %s %s", d2Path(multi, e.A), op, d2Path(multi, e.B))
+		var attrs, style []string
+		if label := r.columnLabel(e); label != "" {
+			attrs = append(attrs, strconv.Quote(label))
+		}
+		switch {
+		case e.Kind == edgeForeignKey:
+			style = append(style, d2FKStyle)
+		case e.Kind == edgeLineage:
+			style = append(style, d2ViewStyle)
+		case e.dynamicOnly():
+			style = append(style, d2DynamicStyle)
+		case e.width() > 1:
+			style = append(style, "style.stroke-width: "+strconv.Itoa(e.width()))
+		}
+		if r.Directed && e.Kind != edgeLineage {
+			aMany, bMany := e.Card.many()
+			style = append(style,
+				"source-arrowhead.shape: "+d2Arrow(aMany), "target-arrowhead.shape: "+d2Arrow(bMany))
+		}
+		if len(style) != 0 {
+			attrs = append(attrs, "{"+strings.Join(style, "; ")+"}")
+		}
+		if len(attrs) != 0 {
+			bw.WriteString(": " + strings.Join(attrs, " "))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// writeTable writes the table as an sql_table shape.
+// With CommentLabel, the table comment is under the name, and the column comments
+// follow the types; with CommentTooltip, the comments are in the tooltip.
+func (r d2Renderer) writeTable(bw *bufio.Writer, indent string, t graphTable) {
+	fmt.Fprintf(bw, "%s%s: {\n%s  shape: sql_table\n", indent, d2Key(t.Name), indent)
+	if lines := r.commentLines(t.Comment); r.CommentLabel && len(lines) != 0 {
+		fmt.Fprintf(bw, "%s  label: %s\n", indent, strconv.Quote(t.Name+"\n"+strings.Join(lines, "\n")))
+	}
+	if tooltip := t.tooltip(); r.CommentTooltip && tooltip != "" {
+		fmt.Fprintf(bw, "%s  tooltip: %s\n", indent, strconv.Quote(tooltip))
+	}
+	if t.View {
+		fmt.Fprintf(bw, "%s  %s\n", indent, d2ViewStyle)
+	}
+	for _, f := range t.Used {
+		typ := f.Type
+		if lines := r.commentLines(f.Comment); r.CommentLabel && len(lines) != 0 {
+			typ += " -- " + strings.Join(lines, " ")
+		}
+		fmt.Fprintf(bw, "%s  %s: %s\n", indent, d2Key(f.Name), d2Key(typ))
+	}
+	fmt.Fprintf(bw, "%s}\n", indent)
+}
+
+// d2Arrow returns the crow's foot arrowhead shape of an edge end.
+func d2Arrow(many bool) string {
+	if many {
+		return "cf-many"
+	}
+	return "cf-one"
+}
+
+// d2Path returns the [owner.]table.field path of the link field,
+// or just the [owner.]table for a composite key.
+func d2Path(withOwner bool, f linkField) string {
+	path := d2Key(f.Table)
+	if len(f.Fields) == 1 {
+		path += "." + d2Key(f.Fields[0])
+	}
+	if withOwner {
+		path = d2Key(f.Owner) + "." + path
+	}
+	return path
+}
+
+// d2Key returns the text as a D2 key, quoted if needed.
+func d2Key(text string) string {
+	if text != "" && simpleID(text) == text {
+		return text
+	}
+	return strconv.Quote(text)
+}
+
+// d2Shapes are the shapes of the object kinds in the dependency graphs.
+var d2Shapes = map[string]string{"PACKAGE": "package", "TABLE": "cylinder", "VIEW": "cylinder"}
+
+// RenderDeps renders the dependency graph, with the cycles filled and red.
+func (r d2Renderer) RenderDeps(w io.Writer, g *depGraph) error {
+	bw := bufio.NewWriter(w)
+	for _, n := range g.Nodes {
+		var attrs []string
+		if shape := d2Shapes[n.Kind]; shape != "" {
+			attrs = append(attrs, "shape: "+shape)
+		}
+		if n.Cycle != 0 {
+			attrs = append(attrs, "style.fill: "+strconv.Quote(cycleColor))
+		}
+		bw.WriteString(d2Key(qualifiedName(n.Owner, n.Name)))
+		if len(attrs) != 0 {
+			bw.WriteString(": {" + strings.Join(attrs, "; ") + "}")
+		}
+		bw.WriteByte('\n')
+	}
+	bw.WriteByte('\n')
+	for _, e := range g.Edges {
+		from, to := g.Nodes[e.From], g.Nodes[e.To]
+		fmt.Fprintf(bw, "%s -> %s", d2Key(qualifiedName(from.Owner, from.Name)), d2Key(qualifiedName(to.Owner, to.Name)))
+		var attrs []string
+		if e.Label != "" {
+			attrs = append(attrs, strconv.Quote(e.Label))
+		}
+		if e.Cycle {
+			attrs = append(attrs, "{style.stroke: red}")
+		}
+		if len(attrs) != 0 {
+			bw.WriteString(": " + strings.Join(attrs, " "))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "sort"
+
+// depGraph is a directed graph of database objects: the call dependencies
+// between the program units (-graph=calls), or the tables used by them (-graph=usage).
+type depGraph struct {
+	Nodes []depNode // sorted by qualified name
+	Edges []depEdge // sorted by From and To
+	// Cycles is the number of the strongly connected components
+	// with more than one node.
+	Cycles int
+}
+
+// depNode is an object of the graph.
+type depNode struct {
+	Owner, Name string
+	Kind        string // PACKAGE, PROCEDURE, FUNCTION, TYPE, TABLE...
+	Cycle       int    // the number of the cycle (from 1) the node is in, or 0
+}
+
+// depEdge points from the node depending on the other one: From and To are node indexes.
+type depEdge struct {
+	From, To int
+	Label    string
+	Cycle    bool // both ends are in the same cycle
+}
+
+// newDepGraph returns the graph of the nodes and the (deduplicated) edges,
+// given as qualified names. The edges with unknown ends are dropped.
+func newDepGraph(nodes []depNode, edges [][2]string) *depGraph {
+	g := &depGraph{Nodes: nodes}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return qualifiedName(g.Nodes[i].Owner, g.Nodes[i].Name) < qualifiedName(g.Nodes[j].Owner, g.Nodes[j].Name)
+	})
+	index := make(map[string]int, len(g.Nodes))
+	for i, n := range g.Nodes {
+		index[qualifiedName(n.Owner, n.Name)] = i
+	}
+	seen := make(map[[2]int]bool, len(edges))
+	for _, e := range edges {
+		from, ok := index[e[0]]
+		if !ok {
+			continue
+		}
+		to, ok := index[e[1]]
+		if !ok || from == to || seen[[2]int{from, to}] {
+			continue
+		}
+		seen[[2]int{from, to}] = true
+		g.Edges = append(g.Edges, depEdge{From: from, To: to})
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	g.markCycles()
+	return g
+}
+
+// markCycles numbers the strongly connected components with more than one node
+// (the circular dependencies) in the order of their first nodes, with Tarjan's algorithm.
+func (g *depGraph) markCycles() {
+	succ := make([][]int, len(g.Nodes))
+	for _, e := range g.Edges {
+		succ[e.From] = append(succ[e.From], e.To)
+	}
+	indexes, lowlinks := make([]int, len(g.Nodes)), make([]int, len(g.Nodes))
+	onStack := make([]bool, len(g.Nodes))
+	var stack []int
+	var components [][]int
+	next := 1 // 0 is not visited
+	var connect func(v int)
+	connect = func(v int) {
+		indexes[v], lowlinks[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range succ[v] {
+			if indexes[w] == 0 {
+				connect(w)
+				if lowlinks[w] < lowlinks[v] {
+					lowlinks[v] = lowlinks[w]
+				}
+			} else if onStack[w] && indexes[w] < lowlinks[v] {
+				lowlinks[v] = indexes[w]
+			}
+		}
+		if lowlinks[v] != indexes[v] {
+			return
+		}
+		var comp []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			comp = append(comp, w)
+			if w == v {
+				break
+			}
+		}
+		if len(comp) > 1 {
+			sort.Ints(comp)
+			components = append(components, comp)
+		}
+	}
+	for v := range g.Nodes {
+		if indexes[v] == 0 {
+			connect(v)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i][0] < components[j][0] })
+	for i, comp := range components {
+		for _, v := range comp {
+			g.Nodes[v].Cycle = i + 1
+		}
+	}
+	g.Cycles = len(components)
+	for i, e := range g.Edges {
+		g.Edges[i].Cycle = g.Nodes[e.From].Cycle != 0 && g.Nodes[e.From].Cycle == g.Nodes[e.To].Cycle
+	}
+}
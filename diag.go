@@ -0,0 +1,200 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+)
+
+// diagnostic is a statement the analysis failed on, or found ambiguous.
+type diagnostic struct {
+	Source  string // qualified name of the source
+	Unit    string // qualified name of the unit (PKG.PROC), or of the source
+	Line    int
+	Excerpt string // the beginning of the statement
+	Rule    string // one of diagnosticRules
+	Message string
+}
+
+// diagnosticRules describes the kinds of the diagnostics.
+var diagnosticRules = map[string]string{
+	"no-from":          "The FROM clause of the query cannot be found.",
+	"no-tables":        "No table can be parsed from the FROM clause.",
+	"no-target":        "The written table of the statement cannot be found.",
+	"unknown-alias":    "The alias of a joined column is not in the FROM clause.",
+	"ambiguous-column": "An unqualified joined column is in more than one table.",
+}
+
+// diagnose returns the problems of the parsed statement, with Rule and Message set.
+func (st statement) diagnose() []diagnostic {
+	var diags []diagnostic
+	add := func(rule, message string) {
+		diags = append(diags, diagnostic{Rule: rule, Message: message})
+	}
+	if st.Kind == "SELECT" && len(st.Queries) == 0 {
+		add("no-from", "cannot find FROM")
+	}
+	if st.Kind != "SELECT" && len(st.Target) == 0 {
+		add("no-target", "cannot find the table of "+st.Kind)
+	}
+	for _, q := range st.Queries {
+		q.walk(func(q *query) {
+			if len(q.Tables) == 0 {
+				add("no-tables", "cannot find tables in FROM")
+			}
+			for _, eq := range q.Equations {
+				for _, c := range eq {
+					if _, ok := q.lookup(c.Alias); !ok && c.Alias != "" {
+						add("unknown-alias", "cannot find table for field "+c.Alias+"."+c.Column)
+					}
+				}
+			}
+		})
+	}
+	return diags
+}
+
+// excerptLength is the maximal length of diagnostic.Excerpt, in runes.
+const excerptLength = 80
+
+// excerpt returns the beginning of the statement, with the whitespace collapsed.
+func excerpt(code string) string {
+	runes := []rune(strings.Join(strings.Fields(code), " "))
+	if len(runes) > excerptLength {
+		return string(runes[:excerptLength-1]) + "…"
+	}
+	return string(runes)
+}
+
+// diagnosticWriters maps the -diagnostics format names to the writers.
+var diagnosticWriters = map[string]func(io.Writer, []diagnostic) error{
+	"json":  writeDiagnosticsJSON,
+	"sarif": writeDiagnosticsSARIF,
+}
+
+// diagnosticWriterNames returns the -diagnostics format names, sorted.
+func diagnosticWriterNames() []string {
+	names := make([]string, 0, len(diagnosticWriters))
+	for k := range diagnosticWriters {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeDiagnosticsJSON(w io.Writer, diags []diagnostic) error {
+	if diags == nil {
+		diags = []diagnostic{}
+	}
+	return writeJSON(w, diags)
+}
+
+// writeDiagnosticsSARIF writes the diagnostics as a SARIF 2.1.0 log,
+// with the sources as the artifacts and the units as the logical locations.
+func writeDiagnosticsSARIF(w io.Writer, diags []diagnostic) error {
+	type (
+		message struct {
+			Text string `json:"text"`
+		}
+		rule struct {
+			ID               string  `json:"id"`
+			ShortDescription message `json:"shortDescription"`
+		}
+		artifactLocation struct {
+			URI string `json:"uri"`
+		}
+		region struct {
+			StartLine int     `json:"startLine"`
+			Snippet   message `json:"snippet"`
+		}
+		physicalLocation struct {
+			ArtifactLocation artifactLocation `json:"artifactLocation"`
+			Region           region           `json:"region"`
+		}
+		logicalLocation struct {
+			FullyQualifiedName string `json:"fullyQualifiedName"`
+		}
+		location struct {
+			PhysicalLocation physicalLocation  `json:"physicalLocation"`
+			LogicalLocations []logicalLocation `json:"logicalLocations"`
+		}
+		result struct {
+			RuleID    string     `json:"ruleId"`
+			Level     string     `json:"level"`
+			Message   message    `json:"message"`
+			Locations []location `json:"locations"`
+		}
+		driver struct {
+			Name           string `json:"name"`
+			InformationURI string `json:"informationUri"`
+			Rules          []rule `json:"rules"`
+		}
+		tool struct {
+			Driver driver `json:"driver"`
+		}
+		run struct {
+			Tool    tool     `json:"tool"`
+			Results []result `json:"results"`
+		}
+		log struct {
+			Schema  string `json:"$schema"`
+			Version string `json:"version"`
+			Runs    []run  `json:"runs"`
+		}
+	)
+	ruleIDs := make([]string, 0, len(diagnosticRules))
+	for id := range diagnosticRules {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	rules := make([]rule, len(ruleIDs))
+	for i, id := range ruleIDs {
+		rules[i] = rule{ID: id, ShortDescription: message{diagnosticRules[id]}}
+	}
+	results := make([]result, len(diags))
+	for i, d := range diags {
+		results[i] = result{RuleID: d.Rule, Level: "warning", Message: message{d.Message},
+			Locations: []location{{
+				PhysicalLocation: physicalLocation{
+					ArtifactLocation: artifactLocation{d.Source},
+					Region:           region{StartLine: d.Line, Snippet: message{d.Excerpt}},
+				},
+				LogicalLocations: []logicalLocation{{d.Unit}},
+			}}}
+	}
+	return writeJSON(w, log{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []run{{
+			Tool:    tool{Driver: driver{Name: "dbdot", InformationURI: "https://github.com/tgulacsi/dbdot", Rules: rules}},
+			Results: results,
+		}},
+	})
+}
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiagnosticsGolden(t *testing.T) {
+	sources := []source{{Name: "DB_BAD", Type: "PACKAGE BODY", Code: `
+PACKAGE BODY DB_BAD IS
+PROCEDURE p IS
+BEGIN
+  SELECT 1 INTO v_x FROM t_contract C, r_item I WHERE C.id = X.contract_id;
+  SELECT 1 INTO v_x FROM t_contract C, t_customer U WHERE id = C.customer_id;
+  SELECT 1 INTO v_x;
+  INSERT INTO (SELECT id FROM t_contract) VALUES (1);
+  SELECT COUNT(*) INTO v_x FROM r_item I, t_contract C WHERE C.id = I.contract_id;
+END p;
+END DB_BAD;
+`}}
+	diags := analyze(testTables, sources, analyzeOptions{}).Diagnostics
+	for _, format := range diagnosticWriterNames() {
+		var buf bytes.Buffer
+		if err := diagnosticWriters[format](&buf, diags); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		checkGolden(t, "diagnostics."+format, buf.Bytes())
+	}
+}
@@ -19,112 +19,257 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"html"
 	"io"
+	"strconv"
 	"strings"
-
-	"github.com/golang/glog"
 )
 
-const html = false
+// fkEdgeStyle is the style of the edges of the declared foreign keys.
+const fkEdgeStyle = "color=blue, style=bold"
+
+// lineageEdgeStyle is the style of the edges from the views to their tables.
+const lineageEdgeStyle = "style=dashed, dir=forward, arrowhead=onormal"
+
+// dynamicEdgeStyle is the style of the joins found only in dynamic SQL.
+const dynamicEdgeStyle = "style=dotted"
 
-func makeDot(w io.Writer, tables []table, sources []source) error {
+func init() {
+	renderers["dot"] = func(opts renderOptions) renderer { return dotRenderer{opts} }
+}
+
+// dotRenderer renders the graph in Graphviz DOT,
+// the tables as record shapes, or HTML-like tables with -style=html.
+type dotRenderer struct {
+	renderOptions
+}
+
+func (r dotRenderer) Render(w io.Writer, g *graph) error {
 	bw := bufio.NewWriter(w)
-	defer bw.Flush()
-
-	fmt.Fprintln(bw, "graph tables {")
-	bw.WriteString("\tnode [shape=record];\n")
-
-	tableNames := make(map[string]struct{}, len(tables))
-	for _, t := range tables {
-		//fmt.Fprintf(bw, "\tgraph %s {\n", t.Name)
-		//bw.WriteString("\t\tnode [shape=record];\n\t\trankdir=LR;\n")
-		tableNames[t.Name] = struct{}{}
-	}
-	usedTables := make(map[string][]string, len(tableNames))
-	// edges
-	edges := make(map[link]struct{}, 512)
-	for _, src := range sources {
-		code := src.Code
-		for _, sel := range getSelects(code) {
-			for _, lnk := range selectGetLinks(sel) {
-				if _, ok := tableNames[lnk.A.Table]; !ok {
-					glog.Infof("%q is not a table name.", lnk.A.Table)
-					continue
-				}
-				if _, ok := tableNames[lnk.B.Table]; !ok {
-					glog.Infof("%q is not a table name.", lnk.B.Table)
-					continue
-				}
-				usedTables[lnk.A.Table] = addString(usedTables[lnk.A.Table], lnk.A.Field)
-				usedTables[lnk.B.Table] = addString(usedTables[lnk.B.Table], lnk.B.Field)
-				edges[lnk] = struct{}{}
-			}
-		}
+
+	graphType, edgeOp := "graph", "--"
+	if r.Directed {
+		graphType, edgeOp = "digraph", "->"
+	}
+	fmt.Fprintln(bw, graphType+" tables {")
+	if r.Style == "html" {
+		bw.WriteString("\tnode [shape=plaintext];\n")
+	} else {
+		bw.WriteString("\tnode [shape=record];\n")
 	}
 
-	// nodes are the tables
-	if html {
+	// nodes are the tables, in a cluster per schema, if there are more than one
+	for _, owner := range g.Owners {
+		indent := "\t"
+		if len(g.Owners) > 1 {
+			fmt.Fprintf(bw, "\tsubgraph %s {\n\t\tlabel=%s;\n", dotID("cluster_"+owner), dotID(owner))
+			indent = "\t\t"
+		}
+		tables, orphans := g.ownerTables(owner)
 		for _, t := range tables {
-			//fmt.Fprintf(bw, "\tgraph %s {\n", t.Name)
-			//bw.WriteString("\t\tnode [shape=record];\n\t\trankdir=LR;\n")
-			fields, ok := usedTables[t.Name]
-			if !ok {
-				glog.Infof("%q not used, skipping.", t.Name)
-				continue
+			r.writeTable(bw, indent, t)
+		}
+		if len(orphans) > 0 {
+			id := "cluster_orphans"
+			if owner != "" {
+				id += "_" + owner
+			}
+			fmt.Fprintf(bw, "%ssubgraph %s {\n%s\tlabel=orphans;\n", indent, dotID(id), indent)
+			for _, t := range orphans {
+				r.writeTable(bw, indent+"\t", t)
 			}
-			fmt.Fprintf(bw, "\t"+`table_%s [style=none, label=<
-<table border="0" cellborder="1" cellspacing="0">
-  <tr><td align="center" bgcolor="BLACK"><font color="WHITE"><b>%s</b></font></td></tr>
-`, t.Name, unocaps(t.Name))
-			for _, fieldName := range fields {
-				for _, f := range t.Fields {
-					if f.Name != fieldName {
-						continue
-					}
-					//fields[f.Name] = append(fields[f.Name], t.Name)
-					fmt.Fprintf(bw, `  <tr><td align="left" PORT="%s">%s %s</td></tr>
-`, f.Name, unocaps(f.Name), f.Type)
-					break
-				}
+			bw.WriteString(indent + "}\n")
+		}
+		if len(g.Owners) > 1 {
+			bw.WriteString("\t}\n")
+		}
+	}
+	bw.WriteByte('\n')
+
+	// edges, from the first columns of the composite keys
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "\t%s %s %s", dotEndpoint(e.A), edgeOp, dotEndpoint(e.B))
+		var attrs []string
+		if r.Directed && e.Kind != edgeLineage {
+			aMany, bMany := e.Card.many()
+			attrs = append(attrs, "dir=both, arrowtail="+dotArrow(aMany)+", arrowhead="+dotArrow(bMany))
+		}
+		switch e.Kind {
+		case edgeForeignKey:
+			attrs = append(attrs, fkEdgeStyle)
+		case edgeLineage:
+			attrs = append(attrs, lineageEdgeStyle)
+		default:
+			if e.dynamicOnly() {
+				attrs = append(attrs, dynamicEdgeStyle)
 			}
-			bw.WriteString("</table>\n>];\n")
-			//bw.WriteString("\"];\n\t}\n")
 		}
+		if width := e.width(); width > 1 {
+			attrs = append(attrs, "penwidth="+strconv.Itoa(width))
+		}
+		if label := r.columnLabel(e); label != "" {
+			attrs = append(attrs, "label="+strconv.Quote(label))
+		}
+		if locations := e.locationList(); locations != "" {
+			attrs = append(attrs, "tooltip="+strconv.Quote(locations))
+		}
+		if len(attrs) != 0 {
+			bw.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+		}
+		bw.WriteString(";\n")
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotEndpoint returns the node:port of the link field,
+// the port being the first column; or just the node if there are no columns.
+func dotEndpoint(f linkField) string {
+	id := nodeID(f.Owner, f.Table)
+	if len(f.Fields) != 0 {
+		id += ":" + dotID(f.Fields[0])
+	}
+	return id
+}
+
+// dotArrow returns the crow's foot arrow shape of an edge end.
+func dotArrow(many bool) string {
+	if many {
+		return "crow"
+	}
+	return "tee"
+}
+
+// writeTable writes the table as a node in the configured style.
+func (r dotRenderer) writeTable(bw *bufio.Writer, indent string, t graphTable) {
+	if r.Style == "html" {
+		r.writeHTMLNode(bw, indent, t)
 	} else {
-		for _, t := range tables {
-			//fmt.Fprintf(bw, "\tgraph %s {\n", t.Name)
-			//bw.WriteString("\t\tnode [shape=record];\n\t\trankdir=LR;\n")
-			fields, ok := usedTables[t.Name]
-			if !ok {
-				glog.Infof("%q not used, skipping.", t.Name)
-				continue
+		r.writeNode(bw, indent, t)
+	}
+}
+
+// writeNode writes the table as a record node, with the used fields.
+func (r dotRenderer) writeNode(bw *bufio.Writer, indent string, t graphTable) {
+	fmt.Fprintf(bw, "%s%s [label=\"{%s", indent, nodeID(t.Owner, t.Name), recordEscape(t.Name))
+	if r.CommentLabel {
+		for _, line := range r.commentLines(t.Comment) {
+			bw.WriteString(`\n` + recordEscape(line))
+		}
+	}
+	for _, f := range t.Used {
+		fmt.Fprintf(bw, "|<%s> %s %s", recordEscape(f.Name), recordEscape(unocaps(f.Name)), recordEscape(f.Type))
+		if r.CommentLabel {
+			for _, line := range r.commentLines(f.Comment) {
+				bw.WriteString(`\n` + recordEscape(line))
 			}
-			fmt.Fprintf(bw, "\ttable_%s [label=\"{%s", t.Name, t.Name)
-			for _, fieldName := range fields {
-				for _, f := range t.Fields {
-					if f.Name != fieldName {
-						continue
-					}
-					fmt.Fprintf(bw, "|<%s> %s %s", f.Name, unocaps(f.Name), f.Type)
-					break
-				}
+		}
+	}
+	bw.WriteString("}\"")
+	color := r.color(t.Name)
+	var style []string
+	if color != "" {
+		style = append(style, "filled")
+	}
+	if t.View {
+		style = append(style, "dashed")
+	}
+	if len(style) != 0 {
+		fmt.Fprintf(bw, ", style=%s", dotID(strings.Join(style, ",")))
+	}
+	if color != "" {
+		fmt.Fprintf(bw, ", fillcolor=%s", dotID(color))
+	}
+	if tooltip := t.tooltip(); r.CommentTooltip && tooltip != "" {
+		fmt.Fprintf(bw, ", tooltip=%s", strconv.Quote(tooltip))
+	}
+	bw.WriteString("];\n")
+}
+
+// writeHTMLNode writes the table as an HTML-like table,
+// with key markers, names, types and (with CommentLabel) comments in separate columns.
+//
+// The cell tooltips need a href, so "#" is used for them.
+func (r dotRenderer) writeHTMLNode(bw *bufio.Writer, indent string, t graphTable) {
+	columns := 3
+	for _, f := range t.Used {
+		if r.CommentLabel && f.Comment != "" {
+			columns = 4
+			break
+		}
+	}
+	header := "<b>" + html.EscapeString(t.Name) + "</b>"
+	if r.CommentLabel {
+		for _, line := range r.commentLines(t.Comment) {
+			header += "<br/><i>" + html.EscapeString(line) + "</i>"
+		}
+	}
+	bgcolor := "black"
+	if color := r.color(t.Name); color != "" {
+		bgcolor = color
+	} else {
+		header = `<font color="white">` + header + `</font>`
+	}
+	tableStyle := ""
+	if t.View {
+		tableStyle = ` border="1" style="dashed"`
+	}
+	fmt.Fprintf(bw, `%s%s [label=<
+<table border="0" cellborder="1" cellspacing="0"%s>
+  <tr><td colspan="%d" align="center" bgcolor="%s"%s>%s</td></tr>
+`, indent, nodeID(t.Owner, t.Name), tableStyle, columns, html.EscapeString(bgcolor), r.htmlTooltip(t.Comment), header)
+	for _, f := range t.Used {
+		fmt.Fprintf(bw, `  <tr><td>%s</td><td align="left" port="%s"%s>%s</td><td align="left">%s</td>`,
+			html.EscapeString(t.keyMarks(f.Name)),
+			html.EscapeString(f.Name), r.htmlTooltip(f.Comment), html.EscapeString(unocaps(f.Name)),
+			html.EscapeString(f.Type))
+		if columns == 4 {
+			lines := r.commentLines(f.Comment)
+			for i, line := range lines {
+				lines[i] = html.EscapeString(line)
 			}
-			bw.WriteString("}\"];\n")
-			//bw.WriteString("\"];\n\t}\n")
+			fmt.Fprintf(bw, `<td align="left">%s</td>`, strings.Join(lines, `<br align="left"/>`))
 		}
+		bw.WriteString("</tr>\n")
 	}
-	bw.WriteByte('\n')
+	bw.WriteString("</table>>];\n")
+}
 
-	// edges
-	for lnk := range edges {
-		fmt.Fprintf(bw, "\ttable_%s:%s -- table_%s:%s;\n",
-			lnk.A.Table, lnk.A.Field,
-			lnk.B.Table, lnk.B.Field,
-		)
+// htmlTooltip returns the tooltip (and href) attributes of a cell,
+// if CommentTooltip is set and the comment is not empty.
+func (r dotRenderer) htmlTooltip(comment string) string {
+	if !r.CommentTooltip || comment == "" {
+		return ""
 	}
+	return ` href="#" tooltip="` + html.EscapeString(comment) + `"`
+}
 
-	fmt.Fprintln(bw, "}")
-	return nil
+// recordEscape escapes the special characters of the record labels.
+func recordEscape(text string) string {
+	return recordReplacer.Replace(text)
+}
+
+var recordReplacer = strings.NewReplacer(
+	"{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`, `"`, `\"`,
+)
+
+// nodeID returns the DOT ID of the table's node.
+func nodeID(owner, name string) string {
+	if owner != "" {
+		name = owner + "__" + name
+	}
+	return dotID("table_" + name)
+}
+
+// dotID returns the text as a DOT ID, quoted if needed.
+func dotID(text string) string {
+	for i, r := range text {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return strconv.Quote(text)
+		}
+	}
+	return text
 }
 
 func unocaps(text string) string {
@@ -135,11 +280,59 @@ func unocaps(text string) string {
 	return strings.ToUpper(text[:i]) + "_" + strings.ToLower(text[i+1:])
 }
 
-func addString(strings []string, elt string) []string {
-	for _, v := range strings {
-		if v == elt {
-			return strings
+// dotShapes are the node shapes of the object kinds in the dependency graphs.
+var dotShapes = map[string]string{"PACKAGE": "folder", "TYPE": "component", "TABLE": "cylinder", "VIEW": "cylinder"}
+
+// RenderDeps renders the dependency graph, with the cycles in red clusters.
+func (r dotRenderer) RenderDeps(w io.Writer, g *depGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph dependencies {\n\tnode [shape=box];\n")
+	for cycle := 0; cycle <= g.Cycles; cycle++ {
+		indent := "\t"
+		if cycle != 0 {
+			fmt.Fprintf(bw, "\tsubgraph cluster_cycle_%d {\n\t\tlabel=\"cycle %d\";\n\t\tcolor=red;\n", cycle, cycle)
+			indent = "\t\t"
+		}
+		for _, n := range g.Nodes {
+			if n.Cycle != cycle {
+				continue
+			}
+			var attrs []string
+			if shape := dotShapes[n.Kind]; shape != "" {
+				attrs = append(attrs, "shape="+shape)
+			}
+			if n.Cycle != 0 {
+				attrs = append(attrs, "style=filled", "fillcolor="+dotID(cycleColor))
+			}
+			if n.Kind != "" {
+				attrs = append(attrs, "tooltip="+strconv.Quote(n.Kind))
+			}
+			bw.WriteString(indent + dotID(qualifiedName(n.Owner, n.Name)))
+			if len(attrs) != 0 {
+				bw.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+			}
+			bw.WriteString(";\n")
+		}
+		if cycle != 0 {
+			bw.WriteString("\t}\n")
+		}
+	}
+	bw.WriteByte('\n')
+	for _, e := range g.Edges {
+		from, to := g.Nodes[e.From], g.Nodes[e.To]
+		fmt.Fprintf(bw, "\t%s -> %s", dotID(qualifiedName(from.Owner, from.Name)), dotID(qualifiedName(to.Owner, to.Name)))
+		var attrs []string
+		if e.Cycle {
+			attrs = append(attrs, "color=red")
+		}
+		if e.Label != "" {
+			attrs = append(attrs, "label="+strconv.Quote(e.Label))
+		}
+		if len(attrs) != 0 {
+			bw.WriteString(" [" + strings.Join(attrs, ", ") + "]")
 		}
+		bw.WriteString(";\n")
 	}
-	return append(strings, elt)
+	bw.WriteString("}\n")
+	return bw.Flush()
 }
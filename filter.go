@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// filterConfig is the include and exclude pattern list of a nameFilter.
+// A pattern is a glob (with * and ?, case insensitive),
+// or a regexp if enclosed in slashes, such as /^T_[A-Z]+$/.
+type filterConfig struct {
+	Include, Exclude []string
+}
+
+// config is the content of the -config file.
+type config struct {
+	Tables, Sources filterConfig
+}
+
+// readConfig reads the JSON config file.
+func readConfig(fn string) (config, error) {
+	var cfg config
+	fh, err := os.Open(fn)
+	if err != nil {
+		return cfg, errgo.Notef(err, "open %q", fn)
+	}
+	defer fh.Close()
+	if err = json.NewDecoder(fh).Decode(&cfg); err != nil {
+		return cfg, errgo.Notef(err, "decode %q", fn)
+	}
+	return cfg, nil
+}
+
+// nameFilter accepts the names which match any of the include patterns
+// (or all if there are no include patterns), but none of the exclude patterns.
+type nameFilter struct {
+	include, exclude []*regexp.Regexp
+}
+
+func newNameFilter(cfg filterConfig) (nameFilter, error) {
+	var f nameFilter
+	var err error
+	if f.include, err = compilePatterns(cfg.Include); err != nil {
+		return f, err
+	}
+	f.exclude, err = compilePatterns(cfg.Exclude)
+	return f, err
+}
+
+// Match reports whether the name is accepted by the filter.
+func (f nameFilter) Match(name string) bool {
+	for _, rx := range f.exclude {
+		if rx.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, rx := range f.include {
+		if rx.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	rxs := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pat := range patterns {
+		if pat = strings.TrimSpace(pat); pat == "" {
+			continue
+		}
+		var expr string
+		if len(pat) > 2 && pat[0] == '/' && pat[len(pat)-1] == '/' {
+			expr = pat[1 : len(pat)-1]
+		} else {
+			expr = globToRegexp(pat)
+		}
+		rx, err := regexp.Compile(expr)
+		if err != nil {
+			return rxs, errgo.Notef(err, "pattern %q", pat)
+		}
+		rxs = append(rxs, rx)
+	}
+	return rxs, nil
+}
+
+// globToRegexp converts the * and ? glob to an anchored, case insensitive regexp.
+func globToRegexp(glob string) string {
+	var buf strings.Builder
+	buf.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteByte('.')
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	buf.WriteByte('$')
+	return buf.String()
+}
+
+// splitList splits the comma separated flag value.
+func splitList(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, ",")
+}
+
+// filterTables returns the tables accepted by the filter.
+func filterTables(tables []table, f nameFilter) []table {
+	filtered := tables[:0]
+	for _, t := range tables {
+		if f.Match(t.Name) {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// filterSources returns the sources accepted by the filter.
+func filterSources(sources []source, f nameFilter) []source {
+	filtered := sources[:0]
+	for _, s := range sources {
+		if f.Match(s.Name) {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered
+}
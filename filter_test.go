@@ -0,0 +1,51 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestNameFilter(t *testing.T) {
+	for i, c := range []struct {
+		Config filterConfig
+		Name   string
+		Match  bool
+	}{
+		{filterConfig{}, "ANY", true},
+		{filterConfig{Include: []string{"T_*", "R_*"}}, "T_CONTRACT", true},
+		{filterConfig{Include: []string{"T_*", "R_*"}}, "t_contract", true},
+		{filterConfig{Include: []string{"T_*", "R_*"}}, "X_T_CONTRACT", false},
+		{filterConfig{Include: []string{"T_*"}, Exclude: []string{"*_LOG"}}, "T_CONTRACT_LOG", false},
+		{filterConfig{Include: []string{"DB_?"}}, "DB_A", true},
+		{filterConfig{Include: []string{"DB_?"}}, "DB_AB", false},
+		{filterConfig{Include: []string{"T.*"}}, "T_A", false},
+		{filterConfig{Include: []string{"/^T_[A-Z]+$/"}}, "T_ABC", true},
+		{filterConfig{Include: []string{"/^T_[A-Z]+$/"}}, "T_AB1", false},
+		{filterConfig{Exclude: []string{"/TMP/"}}, "T_TMP_A", false},
+	} {
+		f, err := newNameFilter(c.Config)
+		if err != nil {
+			t.Errorf("%d. %v", i, err)
+			continue
+		}
+		if got := f.Match(c.Name); got != c.Match {
+			t.Errorf("%d. %+v got %t for %q, awaited %t.", i, c.Config, got, c.Name, c.Match)
+		}
+	}
+	if _, err := newNameFilter(filterConfig{Include: []string{"/(/"}}); err == nil {
+		t.Errorf("awaited error for bad regexp")
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// focus keeps the tables within depth hops (joins and foreign keys) of the named tables,
+// and the edges between them.
+func (g *graph) focus(names []string, depth int) error {
+	starts := make([]string, 0, len(names))
+	for _, name := range names {
+		key, err := g.tableKey(name)
+		if err != nil {
+			return err
+		}
+		starts = append(starts, key)
+	}
+	dist := g.distances(starts...)
+	g.keep(func(key string) bool {
+		d, ok := dist[key]
+		return ok && d <= depth
+	}, nil)
+	return nil
+}
+
+// shortestPaths keeps the tables and the edges on the shortest paths between
+// the two named tables.
+func (g *graph) shortestPaths(from, to string) error {
+	a, err := g.tableKey(from)
+	if err != nil {
+		return err
+	}
+	b, err := g.tableKey(to)
+	if err != nil {
+		return err
+	}
+	distA, distB := g.distances(a), g.distances(b)
+	length, ok := distA[b]
+	if !ok {
+		return errgo.Newf("no path between %q and %q", from, to)
+	}
+	onPath := func(key string) bool {
+		da, okA := distA[key]
+		db, okB := distB[key]
+		return okA && okB && da+db == length
+	}
+	g.keep(onPath, func(e edge) bool {
+		ka, kb := e.A.qualified(), e.B.qualified()
+		return e.Kind != edgeLineage && onPath(ka) && onPath(kb) &&
+			(distA[ka]+1+distB[kb] == length || distA[kb]+1+distB[ka] == length)
+	})
+	return nil
+}
+
+// tableKey returns the qualified name of the table of the graph
+// named as OWNER.NAME, or just NAME, case-insensitively,
+// unless the name matches a qualified name exactly.
+func (g *graph) tableKey(name string) (string, error) {
+	var keys []string
+	for _, t := range g.Tables {
+		key := qualifiedName(t.Owner, t.Name)
+		if key == name {
+			return key, nil
+		}
+		if strings.EqualFold(key, name) || strings.EqualFold(t.Name, name) {
+			keys = append(keys, key)
+		}
+	}
+	switch len(keys) {
+	case 0:
+		return "", errgo.Newf("table %q is not in the graph", name)
+	case 1:
+		return keys[0], nil
+	}
+	return "", errgo.Newf("table %q is ambiguous (%q)", name, keys)
+}
+
+// distances returns the number of the hops from the start tables
+// to the reachable tables (by qualified name), the edges being undirected.
+// Only the joins and the foreign keys are hops, the lineage of the views is not.
+func (g *graph) distances(starts ...string) map[string]int {
+	neighbours := make(map[string][]string, len(g.Tables))
+	for _, e := range g.Edges {
+		if e.Kind == edgeLineage {
+			continue
+		}
+		a, b := e.A.qualified(), e.B.qualified()
+		neighbours[a] = append(neighbours[a], b)
+		neighbours[b] = append(neighbours[b], a)
+	}
+	dist := make(map[string]int, len(g.Tables))
+	queue := make([]string, 0, len(g.Tables))
+	for _, key := range starts {
+		if _, ok := dist[key]; !ok {
+			dist[key] = 0
+			queue = append(queue, key)
+		}
+	}
+	for len(queue) != 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, next := range neighbours[key] {
+			if _, ok := dist[next]; !ok {
+				dist[next] = dist[key] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return dist
+}
+
+// keep keeps the tables accepted by keepTable, and the edges between them
+// (accepted by keepEdge, if not nil).
+func (g *graph) keep(keepTable func(key string) bool, keepEdge func(edge) bool) {
+	tables := g.Tables[:0]
+	owners := g.Owners[:0]
+	for _, t := range g.Tables {
+		if keepTable(qualifiedName(t.Owner, t.Name)) {
+			tables = append(tables, t)
+		}
+	}
+	for _, owner := range g.Owners {
+		for _, t := range tables {
+			if t.Owner == owner {
+				owners = append(owners, owner)
+				break
+			}
+		}
+	}
+	edges := g.Edges[:0]
+	for _, e := range g.Edges {
+		if keepTable(e.A.qualified()) && keepTable(e.B.qualified()) && (keepEdge == nil || keepEdge(e)) {
+			edges = append(edges, e)
+		}
+	}
+	g.Tables, g.Owners, g.Edges = tables, owners, edges
+}
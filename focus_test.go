@@ -0,0 +1,126 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// newChainGraph returns the graph of the A-B-C-D chain, with the A-E-F-D
+// detour, the B-X branch, the unlinked Z, and the V view of A.
+func newChainGraph() *graph {
+	g := &graph{Owners: []string{""}}
+	for _, name := range []string{"A", "B", "C", "D", "E", "F", "X", "Z", "V"} {
+		g.Tables = append(g.Tables, graphTable{table: table{Name: name}})
+	}
+	for _, pair := range []string{"AB", "BC", "CD", "AE", "EF", "DF", "BX", "VA"} {
+		e := edge{link: link{
+			A: linkField{Table: pair[:1], Fields: []string{"ID"}},
+			B: linkField{Table: pair[1:], Fields: []string{"ID"}},
+		}}
+		if pair[0] == 'V' {
+			e.Kind = edgeLineage
+		}
+		g.Edges = append(g.Edges, e)
+	}
+	return g
+}
+
+func graphString(g *graph) string {
+	var parts []string
+	for _, t := range g.Tables {
+		parts = append(parts, t.Name)
+	}
+	parts = append(parts, "|")
+	for _, e := range g.Edges {
+		parts = append(parts, e.A.Table+e.B.Table)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestFocus(t *testing.T) {
+	for i, c := range []struct {
+		Focus   []string
+		Depth   int
+		Awaited string
+	}{
+		{[]string{"A"}, 0, "A |"},
+		{[]string{"A"}, 1, "A B E | AB AE"},
+		{[]string{"A"}, 2, "A B C E F X | AB BC AE EF BX"},
+		{[]string{"X", "Z"}, 1, "B X Z | BX"},
+		{[]string{"a"}, 1, "A B E | AB AE"},
+		{[]string{"V"}, 1, "V |"},
+		{[]string{"A", "V"}, 0, "A V | VA"},
+		{[]string{"NOPE"}, 1, "error"},
+	} {
+		g := newChainGraph()
+		got := "error"
+		if err := g.focus(c.Focus, c.Depth); err == nil {
+			got = graphString(g)
+		}
+		if got != c.Awaited {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c.Awaited)
+		}
+	}
+}
+
+func TestShortestPaths(t *testing.T) {
+	for i, c := range []struct {
+		From, To string
+		Awaited  string
+	}{
+		{"A", "C", "A B C | AB BC"},
+		{"A", "D", "A B C D E F | AB BC CD AE EF DF"},
+		{"X", "C", "B C X | BC BX"},
+		{"x", "c", "B C X | BC BX"},
+		{"A", "Z", "error"},
+		{"V", "B", "error"},
+	} {
+		g := newChainGraph()
+		got := "error"
+		if err := g.shortestPaths(c.From, c.To); err == nil {
+			got = graphString(g)
+		}
+		if got != c.Awaited {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c.Awaited)
+		}
+	}
+}
+
+func TestTableKey(t *testing.T) {
+	g := &graph{}
+	for _, t := range []table{{Owner: "SALES", Name: "T_CONTRACT"}, {Owner: "SALES", Name: "T_ITEM"},
+		{Owner: "REF", Name: "T_ITEM"}, {Owner: "REF", Name: "My Table"}, {Owner: "REF", Name: "MY TABLE"}} {
+		g.Tables = append(g.Tables, graphTable{table: t})
+	}
+	for i, c := range [][2]string{
+		{"t_contract", "SALES.T_CONTRACT"},
+		{"Sales.T_Item", "SALES.T_ITEM"},
+		{"t_item", "error"},
+		{"REF.My Table", "REF.My Table"},
+		{"my table", "error"},
+	} {
+		got, err := g.tableKey(c[0])
+		if err != nil {
+			got = "error"
+		}
+		if got != c[1] {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c[1])
+		}
+	}
+}
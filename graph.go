@@ -0,0 +1,501 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// graph is the result of the analysis, the input of the renderers.
+type graph struct {
+	Owners []string // the schemas, in order of appearance
+	Tables []graphTable
+	Edges  []edge
+	// Usage lists the tables read and written by the sources.
+	Usage []sourceUsage
+	// Diagnostics lists the statements the analysis failed on.
+	Diagnostics []diagnostic
+	// GroupOrphans is true if the unlinked tables should be grouped in an "orphans" cluster.
+	GroupOrphans bool
+}
+
+// graphTable is a table with the fields to be shown.
+type graphTable struct {
+	table
+	Used   []field
+	Orphan bool // not linked to any other table
+}
+
+// sourceUsage is the list of the tables accessed by a source, or a unit of a package.
+type sourceUsage struct {
+	Source string // qualified name, PKG.PROC for a package unit
+	// Owner, Name and Type are of the source object (package, procedure...)
+	Owner, Name, Type string
+	Tables            []tableUsage
+}
+
+// tableUsage is the access of a table: Ops contains C (insert), R (select),
+// U (update) and D (delete), in this order.
+type tableUsage struct {
+	Table string // qualified name
+	Ops   string
+}
+
+// add adds the operations on the table.
+func (su *sourceUsage) add(table, ops string) {
+	for i, tu := range su.Tables {
+		if tu.Table == table {
+			for _, op := range ops {
+				su.Tables[i].Ops = addOp(su.Tables[i].Ops, string(op))
+			}
+			return
+		}
+	}
+	su.Tables = append(su.Tables, tableUsage{Table: table, Ops: ops})
+}
+
+// analyzeOptions are the options of analyze.
+type analyzeOptions struct {
+	// Columns to show: joined (the joined columns only),
+	// keys (the key and the joined columns) or all.
+	Columns string
+	// Unlinked tables are skipped (skip), shown (show),
+	// or shown grouped in an orphans cluster (cluster).
+	Unlinked string
+	// Dynamic analyses the SQL built in strings for EXECUTE IMMEDIATE and OPEN ... FOR, too.
+	Dynamic bool
+}
+
+type edgeKind uint8
+
+const (
+	edgeJoin       edgeKind = iota // join found in the code
+	edgeForeignKey                 // declared foreign key
+	edgeLineage                    // view (A) reading a table or view (B)
+)
+
+func (k edgeKind) String() string {
+	switch k {
+	case edgeForeignKey:
+		return "foreign key"
+	case edgeLineage:
+		return "lineage"
+	}
+	return "join"
+}
+
+// cardinality of an edge, inferred from the primary and unique keys.
+type cardinality uint8
+
+const (
+	cardManyToMany cardinality = iota // N:M, neither side is unique
+	cardOneToMany                     // 1:N, B is unique
+	cardOneToOne                      // 1:1, both sides are unique
+)
+
+func (c cardinality) String() string {
+	switch c {
+	case cardOneToMany:
+		return "1:N"
+	case cardOneToOne:
+		return "1:1"
+	}
+	return "N:M"
+}
+
+// many returns whether the A (from) and the B (to) sides are the "many" sides.
+func (c cardinality) many() (a, b bool) {
+	switch c {
+	case cardOneToMany:
+		return true, false
+	case cardOneToOne:
+		return false, false
+	}
+	return true, true
+}
+
+// edge is a link between two tables, with the number of the joins
+// found in the code (Dynamic of them in dynamic SQL),
+// the (qualified) names of the sources (or package units) they came from,
+// and the UNIT:line locations of the joins.
+//
+// The edge points from A to B: from the foreign key to the referenced key,
+// or from the non-unique to the unique side of the join.
+type edge struct {
+	link
+	Kind      edgeKind
+	Card      cardinality
+	Count     int
+	Dynamic   int
+	Sources   []string
+	Locations []string
+}
+
+// analyze collects the links from the sources and the foreign keys,
+// and returns the tables (with the fields to be shown) and the edges.
+func analyze(tables []table, sources []source, opts analyzeOptions) *graph {
+	cat := newCatalog(tables)
+	usedTables := make(map[string][]string, len(tables))
+	// use marks the tables of the link as used, even without fields
+	// (as the lineage of a view from a table it only filters by)
+	use := func(lnk link) {
+		for _, f := range [2]linkField{lnk.A, lnk.B} {
+			fields := usedTables[f.qualified()]
+			for _, fieldName := range f.Fields {
+				fields = addString(fields, fieldName)
+			}
+			usedTables[f.qualified()] = fields
+		}
+	}
+	// edges
+	edges := make(map[string]*edge, 512)
+	getEdge := func(key string, lnk link) *edge {
+		e := edges[key]
+		if e == nil {
+			e = &edge{link: lnk}
+			edges[key] = e
+		}
+		return e
+	}
+	var usage []sourceUsage
+	var diagnostics []diagnostic
+	usageIndex := make(map[string]int)
+	// scan collects the links of the statements of the source
+	// (and of the dynamic SQL with opts.Dynamic),
+	// and the usage of the tables by its units if withUsage is set.
+	scan := func(src source, withUsage bool) []statement {
+		units := src.units()
+		codes, lines := locateStatements(src.Code)
+		static := len(codes)
+		if opts.Dynamic {
+			dynCodes, dynLines := getDynamicStatements(src.Code)
+			codes, lines = append(codes, dynCodes...), append(lines, dynLines...)
+		}
+		var stmts []statement
+		for i, code := range codes {
+			unit := qualifiedName(src.Owner, src.unitAt(units, lines[i]))
+			access := func(ref tableRef, ops string) {
+				if !withUsage || ops == "" {
+					return
+				}
+				t, ok := cat.resolve(ref.Owner, ref.Name, src.Owner)
+				if !ok {
+					return
+				}
+				j, ok := usageIndex[unit]
+				if !ok {
+					j = len(usage)
+					usageIndex[unit] = j
+					usage = append(usage, sourceUsage{Source: unit,
+						Owner: src.Owner, Name: src.Name, Type: src.Type})
+				}
+				usage[j].add(qualifiedName(t.Owner, t.Name), ops)
+			}
+			st := parseStatement(significant(lex(code)))
+			var diags []diagnostic
+			for _, q := range st.Queries {
+				for _, col := range cat.qualify(q, src.Owner) {
+					glog.Warningf("%s:%d: ambiguous column %s.", unit, lines[i], col)
+					diags = append(diags, diagnostic{Rule: "ambiguous-column", Message: "ambiguous column " + col})
+				}
+			}
+			for _, d := range append(st.diagnose(), diags...) {
+				d.Source, d.Unit, d.Line, d.Excerpt = qualifiedName(src.Owner, src.Name), unit, lines[i], excerpt(code)
+				diagnostics = append(diagnostics, d)
+			}
+			for _, lnk := range st.links() {
+				lnk, ok := cat.resolveLink(lnk, src.Owner)
+				if !ok {
+					continue
+				}
+				use(lnk)
+				e := getEdge(lnk.key(), lnk)
+				e.Count++
+				if i >= static {
+					e.Dynamic++
+				}
+				e.Sources = addString(e.Sources, unit)
+				e.Locations = addString(e.Locations, unit+":"+strconv.Itoa(lines[i]))
+			}
+			for _, ref := range st.Target {
+				access(ref, st.Ops)
+			}
+			for _, ref := range st.reads() {
+				access(ref, "R")
+			}
+			stmts = append(stmts, st)
+		}
+		return stmts
+	}
+	for _, src := range sources {
+		scan(src, true)
+	}
+	fromB := make(map[string]bool) // the edge points from B to A
+	// the joins in the views, and the lineage of the views
+	for _, t := range tables {
+		if !t.View || t.Text == "" {
+			continue
+		}
+		for _, st := range scan(source{Owner: t.Owner, Name: t.Name, Code: t.Text}, false) {
+			for _, lnk := range t.lineage(st) {
+				lnk, ok := cat.resolveLink(lnk, t.Owner)
+				if !ok {
+					continue
+				}
+				use(lnk)
+				key := "lineage:" + lnk.key()
+				getEdge(key, lnk).Kind = edgeLineage
+				fromB[key] = lnk.B.Owner == t.Owner && lnk.B.Table == t.Name
+			}
+		}
+	}
+	// declared foreign keys
+	for _, t := range tables {
+		for _, lnk := range t.foreignLinks() {
+			lnk, ok := cat.resolveForeignLink(lnk, t.Owner)
+			if !ok {
+				continue
+			}
+			use(lnk)
+			getEdge(lnk.key(), lnk).Kind = edgeForeignKey
+			// a self-referencing foreign key is already from A
+			fromB[lnk.key()] = lnk.B.Owner == t.Owner && lnk.B.Table == t.Name &&
+				lnk.A.qualified() != lnk.B.qualified()
+		}
+	}
+	for key, e := range edges {
+		if e.Kind == edgeLineage {
+			if fromB[key] {
+				e.A, e.B = e.B, e.A
+			}
+			continue
+		}
+		ta, _ := cat.resolve(e.A.Owner, e.A.Table, "")
+		tb, _ := cat.resolve(e.B.Owner, e.B.Table, "")
+		aUnique, bUnique := ta.isUnique(e.A.Fields), tb.isUnique(e.B.Fields)
+		swap := false
+		if e.Kind == edgeForeignKey {
+			// the referenced side is unique by definition
+			swap = fromB[key]
+			if swap {
+				aUnique, bUnique = bUnique, true
+			} else {
+				bUnique = true
+			}
+		} else if aUnique && !bUnique {
+			swap, aUnique, bUnique = true, false, true
+		}
+		if swap {
+			e.A, e.B = e.B, e.A
+		}
+		switch {
+		case aUnique && bUnique:
+			e.Card = cardOneToOne
+		case bUnique:
+			e.Card = cardOneToMany
+		}
+	}
+
+	g := &graph{Edges: make([]edge, 0, len(edges)), Usage: usage, Diagnostics: diagnostics,
+		GroupOrphans: opts.Unlinked == "cluster"}
+	for _, t := range tables {
+		g.Owners = addString(g.Owners, t.Owner)
+		fields, ok := usedTables[qualifiedName(t.Owner, t.Name)]
+		if !ok && (opts.Unlinked == "" || opts.Unlinked == "skip") {
+			glog.Infof("%q not used, skipping.", t.Name)
+			continue
+		}
+		gt := graphTable{table: t, Orphan: !ok}
+		switch opts.Columns {
+		case "all":
+			gt.Used = t.Fields
+		case "keys":
+			gt.Used = make([]field, 0, len(t.Fields))
+			for _, f := range t.Fields {
+				if t.keyMarks(f.Name) != "" || containsString(fields, f.Name) {
+					gt.Used = append(gt.Used, f)
+				}
+			}
+		default:
+			gt.Used = make([]field, 0, len(fields))
+			for _, fieldName := range fields {
+				for _, f := range t.Fields {
+					if f.Name == fieldName {
+						gt.Used = append(gt.Used, f)
+						break
+					}
+				}
+			}
+		}
+		g.Tables = append(g.Tables, gt)
+	}
+	for _, e := range edges {
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool { return g.Edges[i].less(g.Edges[j]) })
+	return g
+}
+
+func (e edge) less(f edge) bool {
+	for _, ab := range [][2]string{
+		{e.A.qualified(), f.A.qualified()}, {e.B.qualified(), f.B.qualified()},
+		{strings.Join(e.A.Fields, ","), strings.Join(f.A.Fields, ",")},
+		{strings.Join(e.B.Fields, ","), strings.Join(f.B.Fields, ",")},
+	} {
+		if ab[0] != ab[1] {
+			return ab[0] < ab[1]
+		}
+	}
+	return e.Kind < f.Kind
+}
+
+// keyMarks returns the PK, UK and FK markers of the field, such as "PK,FK".
+func (t table) keyMarks(fieldName string) string {
+	var marks []string
+	for _, k := range [...]struct{ Type, Mark string }{{"P", "PK"}, {"U", "UK"}, {"R", "FK"}} {
+		for _, c := range t.Constraints {
+			if c.Type != k.Type {
+				continue
+			}
+			for _, f := range c.Fields {
+				if f == fieldName {
+					marks = addString(marks, k.Mark)
+				}
+			}
+		}
+	}
+	return strings.Join(marks, ",")
+}
+
+// lineage returns the links from the view (A) to the tables its query reads (B),
+// with the view's columns and the columns they are selected from.
+// A table without such columns (only joined or filtered) has a link with no fields.
+func (t table) lineage(st statement) []link {
+	if st.Kind != "SELECT" || len(st.Queries) == 0 {
+		return nil
+	}
+	var links []link
+	index := make(map[tableRef]int)
+	add := func(ref tableRef, viewField, field string) {
+		ref.Sub = nil
+		i, ok := index[ref]
+		if !ok {
+			i = len(links)
+			index[ref] = i
+			links = append(links, link{
+				A: linkField{Owner: t.Owner, Table: t.Name, Fields: []string{}},
+				B: linkField{Owner: ref.Owner, Table: ref.Name, Fields: []string{}},
+			})
+		}
+		if viewField != "" {
+			links[i].A.Fields = append(links[i].A.Fields, viewField)
+			links[i].B.Fields = append(links[i].B.Fields, field)
+		}
+	}
+	for q := st.Queries[0]; q != nil; q = q.Next {
+		// the columns of the view are the columns of the select list, by position,
+		// unless there is a * in it
+		positional := len(q.Columns) == len(t.Fields)
+		for _, c := range q.Columns {
+			if c.Name == "*" {
+				positional = false
+			}
+		}
+		for i, f := range t.Fields {
+			var ref tableRef
+			var col string
+			var ok bool
+			if positional {
+				if c := q.Columns[i]; c.Ref != nil {
+					ref, col, ok = q.resolveColumn(*c.Ref, 0)
+				}
+			} else {
+				ref, col, ok = q.output(f.Name, 0)
+			}
+			if ok {
+				add(ref, f.Name, col)
+			}
+		}
+	}
+	for _, ref := range st.reads() {
+		add(ref, "", "")
+	}
+	result := make([]link, 0, len(links))
+	for _, lnk := range links {
+		if lnk, ok := newLink(lnk.A, lnk.B); ok {
+			result = append(result, lnk)
+		}
+	}
+	return result
+}
+
+// isUnique reports whether the fields are unique in the table:
+// whether they contain all the columns of a primary or unique key.
+func (t *table) isUnique(fieldNames []string) bool {
+Constraints:
+	for _, c := range t.Constraints {
+		if !(c.Type == "P" || c.Type == "U") {
+			continue
+		}
+		for _, f := range c.Fields {
+			if !containsString(fieldNames, f) {
+				continue Constraints
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ownerTables returns the tables of the owner.
+// If the orphans are grouped, the orphans are returned separately.
+func (g *graph) ownerTables(owner string) (tables, orphans []graphTable) {
+	tables = make([]graphTable, 0, len(g.Tables))
+	for _, t := range g.Tables {
+		if t.Owner != owner {
+			continue
+		}
+		if g.GroupOrphans && t.Orphan {
+			orphans = append(orphans, t)
+		} else {
+			tables = append(tables, t)
+		}
+	}
+	return tables, orphans
+}
+
+func containsString(strings []string, elt string) bool {
+	for _, v := range strings {
+		if v == elt {
+			return true
+		}
+	}
+	return false
+}
+
+func addString(strings []string, elt string) []string {
+	for _, v := range strings {
+		if v == elt {
+			return strings
+		}
+	}
+	return append(strings, elt)
+}
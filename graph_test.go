@@ -0,0 +1,153 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeCardinality(t *testing.T) {
+	tables := []table{
+		{Name: "A", Fields: []field{{"ID", "NUMBER", ""}, {"B_ID", "NUMBER", ""}},
+			Constraints: []constraint{{Name: "A_PK", Type: "P", Fields: []string{"ID"}}}},
+		{Name: "B", Fields: []field{{"ID", "NUMBER", ""}, {"A_ID", "NUMBER", ""}, {"X", "NUMBER", ""}},
+			Constraints: []constraint{
+				{Name: "B_PK", Type: "P", Fields: []string{"ID"}},
+				{Name: "B_UK", Type: "U", Fields: []string{"A_ID"}},
+				{Name: "B_FK", Type: "R", Fields: []string{"A_ID"}, RefTable: "A", RefFields: []string{"ID"}},
+			}},
+	}
+	sources := []source{{Name: "P", Code: `
+SELECT 1 FROM a, b WHERE b.id = a.b_id;
+SELECT 1 FROM a, b WHERE b.x = a.b_id;`}}
+	g := analyze(tables, sources, analyzeOptions{})
+	awaited := []string{
+		"A.B_ID->B.ID 1:N",
+		"A.B_ID->B.X N:M",
+		"B.A_ID->A.ID 1:1",
+	}
+	if len(g.Edges) != len(awaited) {
+		t.Fatalf("got %d edges (%v), awaited %d.", len(g.Edges), g.Edges, len(awaited))
+	}
+	for i, e := range g.Edges {
+		got := e.A.Table + "." + strings.Join(e.A.Fields, ",") + "->" +
+			e.B.Table + "." + strings.Join(e.B.Fields, ",") + " " + e.Card.String()
+		if got != awaited[i] {
+			t.Errorf("%d. got %q, awaited %q.", i, got, awaited[i])
+		}
+	}
+}
+
+func TestAnalyzeUsage(t *testing.T) {
+	sources := []source{{Name: "P", Code: `
+BEGIN
+  SELECT COUNT(*) INTO v_cnt FROM t_item I, t_contract C WHERE C.id = I.contract_id;
+  UPDATE t_contract SET amount = 0 WHERE id = p_id;
+  DELETE FROM t_item WHERE contract_id = p_id;
+  INSERT INTO t_unknown (id) VALUES (1);
+END;`}}
+	tables := []table{{Name: "T_CONTRACT"}, {Name: "T_ITEM"}}
+	g := analyze(tables, sources, analyzeOptions{})
+	if len(g.Usage) != 1 || g.Usage[0].Source != "P" {
+		t.Fatalf("got %+v, awaited usage of P.", g.Usage)
+	}
+	var got []string
+	for _, tu := range g.Usage[0].Tables {
+		got = append(got, tu.Table+":"+tu.Ops)
+	}
+	if awaited := "T_ITEM:RD T_CONTRACT:RU"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", strings.Join(got, " "), awaited)
+	}
+}
+
+func TestAnalyzeDynamic(t *testing.T) {
+	tables := []table{
+		{Name: "A", Fields: []field{{"ID", "NUMBER", ""}, {"B_ID", "NUMBER", ""}}},
+		{Name: "B", Fields: []field{{"ID", "NUMBER", ""}}},
+	}
+	sources := []source{{Name: "P", Code: `
+BEGIN
+  EXECUTE IMMEDIATE 'SELECT COUNT(*) FROM a, b WHERE b.id = a.b_id' INTO v_cnt;
+END;`}}
+	for i, dynamic := range []bool{false, true} {
+		g := analyze(tables, sources, analyzeOptions{Dynamic: dynamic})
+		if !dynamic {
+			if len(g.Edges) != 0 {
+				t.Errorf("%d. got %v, awaited no edges.", i, g.Edges)
+			}
+			continue
+		}
+		if len(g.Edges) != 1 {
+			t.Fatalf("%d. got %v, awaited 1 edge.", i, g.Edges)
+		}
+		if e := g.Edges[0]; e.Count != 1 || e.Dynamic != 1 || !e.dynamicOnly() {
+			t.Errorf("%d. got %+v, awaited a dynamic only edge.", i, e)
+		}
+	}
+}
+
+func TestAnalyzeUnqualified(t *testing.T) {
+	tables := []table{
+		{Name: "A", Fields: []field{{"ID", "NUMBER", ""}, {"B_ID", "NUMBER", ""}}},
+		{Name: "B", Fields: []field{{"ID", "NUMBER", ""}, {"NAME", "VARCHAR2", ""}}},
+		{Name: "C", Fields: []field{{"ID", "NUMBER", ""}, {"B_ID", "NUMBER", ""}}},
+	}
+	sources := []source{{Name: "P", Code: `
+BEGIN
+  SELECT 1 INTO v_x FROM a, b WHERE b_id = b.id AND name = :p_name;
+  SELECT 1 INTO v_x FROM a, c, b WHERE b_id = b.id;
+  UPDATE c SET id = 1 WHERE EXISTS (SELECT 1 FROM b WHERE b.id = b_id);
+END;`}}
+	g := analyze(tables, sources, analyzeOptions{})
+	var got []string
+	for _, e := range g.Edges {
+		got = append(got, e.A.Table+"."+strings.Join(e.A.Fields, ",")+"="+e.B.Table+"."+strings.Join(e.B.Fields, ","))
+	}
+	if awaited := "A.B_ID=B.ID B.ID=C.B_ID"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", strings.Join(got, " "), awaited)
+	}
+
+	q := parseQuery(significant(lex("SELECT 1 FROM a x, c y, b WHERE b_id = b.id")))
+	if got := newCatalog(tables).qualify(q, ""); strings.Join(got, " ") != "B_ID (X, Y)" {
+		t.Errorf("got %q, awaited the ambiguous B_ID (X, Y).", got)
+	}
+}
+
+func TestAnalyzeNotColumns(t *testing.T) {
+	tables := []table{
+		{Name: "ATAB", Fields: []field{{"ID", "NUMBER", ""}, {"F", "DATE", ""}}},
+		{Name: "BTAB", Fields: []field{{"ID", "NUMBER", ""}, {"A_ID", "NUMBER", ""}, {"D", "DATE", ""}}},
+	}
+	for i, c := range []struct {
+		Code, Links string
+	}{
+		{"SELECT 1 INTO v_x FROM atab a WHERE EXISTS (SELECT 1 FROM btab WHERE p_x = a.f);", ""},
+		{"SELECT 1 INTO v_x FROM atab a WHERE EXISTS (SELECT 1 FROM btab WHERE a.f = SYSDATE);", ""},
+		{"SELECT 1 INTO v_x FROM atab a WHERE EXISTS (SELECT 1 FROM btab WHERE a.f = NVL(d, SYSDATE));", ""},
+		{"SELECT 1 INTO v_x FROM atab a WHERE EXISTS (SELECT 1 FROM btab WHERE a_id = a.id);", "ATAB.ID=BTAB.A_ID"},
+	} {
+		g := analyze(tables, []source{{Name: "P", Code: c.Code}}, analyzeOptions{})
+		var got []string
+		for _, e := range g.Edges {
+			got = append(got, e.A.Table+"."+strings.Join(e.A.Fields, ",")+"="+e.B.Table+"."+strings.Join(e.B.Fields, ","))
+		}
+		if strings.Join(got, " ") != c.Links {
+			t.Errorf("%d. got %q, awaited %q (%q).", i, strings.Join(got, " "), c.Links, c.Code)
+		}
+	}
+}
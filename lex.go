@@ -0,0 +1,279 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind uint8
+
+const (
+	tokIdent       tokenKind = iota // plain identifier
+	tokQuotedIdent                  // "My Table"
+	tokKeyword                      // reserved word, such as SELECT
+	tokString                       // 'abc', q'[abc]', N'abc'
+	tokNumber                       // 12, 1.5e3
+	tokComment                      // -- line or /* block */
+	tokOperator                     // punctuation and operators: ( ) , ; . = <> := ||
+)
+
+var tokenKindNames = [...]string{
+	tokIdent:       "ident",
+	tokQuotedIdent: "quoted",
+	tokKeyword:     "keyword",
+	tokString:      "string",
+	tokNumber:      "number",
+	tokComment:     "comment",
+	tokOperator:    "operator",
+}
+
+func (k tokenKind) String() string {
+	if int(k) < len(tokenKindNames) {
+		return tokenKindNames[k]
+	}
+	return fmt.Sprintf("tokenKind(%d)", k)
+}
+
+// token is one lexical element of SQL or PL/SQL code.
+// Text is the original text, Pos and End are byte offsets in the code,
+// Line is 1-based.
+type token struct {
+	Kind     tokenKind
+	Text     string
+	Pos, End int
+	Line     int
+}
+
+func (t token) String() string {
+	return t.Kind.String() + ":" + t.Text
+}
+
+// is reports whether the token is the given keyword or operator.
+func (t token) is(text string) bool {
+	switch t.Kind {
+	case tokKeyword, tokIdent:
+		return strings.EqualFold(t.Text, text)
+	case tokOperator:
+		return t.Text == text
+	}
+	return false
+}
+
+// isName reports whether the token can be an object or column name.
+func (t token) isName() bool {
+	return t.Kind == tokIdent || t.Kind == tokQuotedIdent
+}
+
+// name returns the normalized name: plain identifiers are upper cased,
+// quoted identifiers are returned as is, without the quotes.
+func (t token) name() string {
+	if t.Kind == tokQuotedIdent {
+		return strings.Replace(t.Text[1:len(t.Text)-1], `""`, `"`, -1)
+	}
+	return strings.ToUpper(t.Text)
+}
+
+// keywords are the reserved words we care about in the parser.
+// Everything else is an identifier, so a column named TYPE or NAME stays a name.
+var keywords = make(map[string]struct{}, 128)
+
+func init() {
+	for _, kw := range strings.Fields(`
+		ALL AND ANY AS ASC BEGIN BETWEEN BODY BULK BY CASE CLOSE COLLECT CONNECT
+		CREATE CROSS CURSOR DECLARE DEFAULT DELETE DESC DISTINCT ELSE ELSIF END
+		EXCEPTION EXECUTE EXISTS FETCH FOR FROM FULL FUNCTION GROUP HAVING IF
+		IMMEDIATE IN INNER INSERT INTERSECT INTO IS JOIN LEFT LIKE LOOP MERGE
+		MINUS NATURAL NOT NULL OF ON OPEN OR ORDER OUTER PACKAGE PROCEDURE
+		RETURN RETURNING RIGHT SELECT SET START THEN UNION UPDATE USING VALUES
+		VIEW WHEN WHERE WHILE WITH`) {
+		keywords[kw] = struct{}{}
+	}
+}
+
+// lex splits the code into tokens. Whitespace is dropped, comments are kept
+// (use significant to drop them). The lexer never fails: an unterminated
+// string or comment runs until the end of the code.
+func lex(code string) []token {
+	toks := make([]token, 0, len(code)/4)
+	line := 1
+	for i := 0; i < len(code); {
+		r, size := utf8.DecodeRuneInString(code[i:])
+		if unicode.IsSpace(r) {
+			if r == '\n' {
+				line++
+			}
+			i += size
+			continue
+		}
+		start, kind := i, tokOperator
+		switch {
+		case r == '-' && strings.HasPrefix(code[i:], "--"):
+			kind = tokComment
+			if j := strings.IndexByte(code[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(code)
+			}
+		case r == '/' && strings.HasPrefix(code[i:], "/*"):
+			kind = tokComment
+			if j := strings.Index(code[i+2:], "*/"); j >= 0 {
+				i += 2 + j + 2
+			} else {
+				i = len(code)
+			}
+		case r == '\'':
+			kind, i = tokString, endString(code, i+1)
+		case (r == 'q' || r == 'Q') && i+2 < len(code) && code[i+1] == '\'':
+			kind, i = tokString, endQString(code, i+2)
+		case (r == 'n' || r == 'N') && i+1 < len(code) && code[i+1] == '\'':
+			kind, i = tokString, endString(code, i+2)
+		case (r == 'n' || r == 'N') && i+3 < len(code) &&
+			(code[i+1] == 'q' || code[i+1] == 'Q') && code[i+2] == '\'':
+			kind, i = tokString, endQString(code, i+3)
+		case r == '"':
+			kind = tokQuotedIdent
+			for i++; i < len(code); i++ {
+				if code[i] == '"' {
+					if i+1 < len(code) && code[i+1] == '"' {
+						i++
+						continue
+					}
+					i++
+					break
+				}
+			}
+		case isIdentStart(r):
+			kind = tokIdent
+			for i += size; i < len(code); i += size {
+				if r, size = utf8.DecodeRuneInString(code[i:]); !isIdentPart(r) {
+					break
+				}
+			}
+			if _, ok := keywords[strings.ToUpper(code[start:i])]; ok {
+				kind = tokKeyword
+			}
+		case r >= '0' && r <= '9' || r == '.' && i+1 < len(code) && code[i+1] >= '0' && code[i+1] <= '9':
+			kind, i = tokNumber, endNumber(code, i)
+		default:
+			i += size
+			if i < len(code) {
+				switch code[start:i] + code[i:i+1] {
+				case "<>", "!=", "^=", ">=", "<=", ":=", "||", "=>", "..", "**":
+					i++
+				}
+			}
+		}
+		toks = append(toks, token{Kind: kind, Text: code[start:i], Pos: start, End: i, Line: line})
+		line += strings.Count(code[start:i], "\n")
+	}
+	return toks
+}
+
+// endString returns the position after the closing quote of a '...' string,
+// where a doubled quote is an escaped one. i is the position after the opening quote.
+func endString(code string, i int) int {
+	for ; i < len(code); i++ {
+		if code[i] != '\'' {
+			continue
+		}
+		if i+1 < len(code) && code[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return len(code)
+}
+
+// endQString returns the position after the end of a q'[...]' string.
+// i is the position of the opening delimiter.
+func endQString(code string, i int) int {
+	closing := code[i]
+	switch closing {
+	case '[':
+		closing = ']'
+	case '{':
+		closing = '}'
+	case '(':
+		closing = ')'
+	case '<':
+		closing = '>'
+	}
+	if j := strings.Index(code[i+1:], string(closing)+"'"); j >= 0 {
+		return i + 1 + j + 2
+	}
+	return len(code)
+}
+
+func endNumber(code string, i int) int {
+	for ; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.':
+			if i+1 < len(code) && code[i+1] == '.' { // 1..10
+				return i
+			}
+		case (c == 'e' || c == 'E') && i+1 < len(code) &&
+			(code[i+1] >= '0' && code[i+1] <= '9' || code[i+1] == '-' || code[i+1] == '+'):
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return r == '_' || r == '$' || r == '#' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// significant returns the tokens without the comments.
+func significant(toks []token) []token {
+	sig := make([]token, 0, len(toks))
+	for _, t := range toks {
+		if t.Kind != tokComment {
+			sig = append(sig, t)
+		}
+	}
+	return sig
+}
+
+// matchingBracket returns the index of the ")" closing the "(" at toks[i],
+// or -1 if there is no such.
+func matchingBracket(toks []token, i int) int {
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		switch {
+		case toks[j].is("("):
+			depth++
+		case toks[j].is(")"):
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
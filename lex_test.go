@@ -0,0 +1,89 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	for i, c := range []struct {
+		Code   string
+		Tokens string
+	}{
+		{"", ""},
+		{"SELECT a FROM b", "keyword:SELECT ident:a keyword:FROM ident:b"},
+		{"a.b=c.d(+)", "ident:a operator:. ident:b operator:= ident:c operator:. ident:d operator:( operator:+ operator:)"},
+		{"x := 1.5e3||'a';", "ident:x operator::= number:1.5e3 operator:|| string:'a' operator:;"},
+		{"a<>b AND c!=d", "ident:a operator:<> ident:b keyword:AND ident:c operator:!= ident:d"},
+		{"FOR i IN 1..10", "keyword:FOR ident:i keyword:IN number:1 operator:.. number:10"},
+		{"'it''s' x", "string:'it''s' ident:x"},
+		{"q'[it's]' x", "string:q'[it's]' ident:x"},
+		{"Q'{a'b}' x", "string:Q'{a'b}' ident:x"},
+		{"q'!a'b!' x", "string:q'!a'b!' ident:x"},
+		{"N'abc' nq'(d)'", "string:N'abc' string:nq'(d)'"},
+		{`"My Table" t`, `quoted:"My Table" ident:t`},
+		{`"a""b"`, `quoted:"a""b"`},
+		{"a -- b 'c\nd", "ident:a comment:-- b 'c ident:d"},
+		{"a /* b\n-- c */ d", "ident:a comment:/* b\n-- c */ ident:d"},
+		{"'--not a comment' '/*'", "string:'--not a comment' string:'/*'"},
+		{"v_$x# sys.dual@link", "ident:v_$x# ident:sys operator:. ident:dual operator:@ ident:link"},
+		{"'unterminated", "string:'unterminated"},
+		{"/* unterminated", "comment:/* unterminated"},
+		{"árvíztűrő_tükörfúrógép", "ident:árvíztűrő_tükörfúrógép"},
+		{"type name", "ident:type ident:name"},
+	} {
+		toks := lex(c.Code)
+		got := make([]string, len(toks))
+		for j, tok := range toks {
+			got[j] = tok.String()
+			if c.Code[tok.Pos:tok.End] != tok.Text {
+				t.Errorf("%d. %d position mismatch: %q != %q", i, j, c.Code[tok.Pos:tok.End], tok.Text)
+			}
+		}
+		if strings.Join(got, " ") != c.Tokens {
+			t.Errorf("%d. got %q, awaited %q.", i, strings.Join(got, " "), c.Tokens)
+		}
+	}
+}
+
+func TestLexLine(t *testing.T) {
+	toks := lex("a\n/* b\nc */ d\n'e\nf' g")
+	for i, line := range []int{1, 2, 3, 4, 5} {
+		if toks[i].Line != line {
+			t.Errorf("%d. %v got line %d, awaited %d.", i, toks[i], toks[i].Line, line)
+		}
+	}
+}
+
+func TestTokenName(t *testing.T) {
+	for i, c := range [][2]string{
+		{"abc", "ABC"},
+		{`"My Table"`, "My Table"},
+		{`"a""b"`, `a"b`},
+	} {
+		toks := lex(c[0])
+		if len(toks) != 1 {
+			t.Errorf("%d. got %d tokens for %q.", i, len(toks), c[0])
+			continue
+		}
+		if got := toks[0].name(); got != c[1] {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c[1])
+		}
+	}
+}
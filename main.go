@@ -18,26 +18,134 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"flag"
-	"io"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/golang/glog"
-	_ "github.com/tgulacsi/goracle/godrv"
 	"gopkg.in/errgo.v1"
 )
 
 func main() {
+	flagDriver := flag.String("driver", "goracle", "database driver ("+strings.Join(providerNames(), ", ")+")")
 	flagDsn := flag.String("connect", "", "database connection string")
+	flagSchemas := flag.String("schemas", "", "comma separated list of schemas (owners) to read, instead of the user's own")
+	flagDBA := flag.Bool("dba", false, "read the DBA_* views instead of the ALL_* views (with -schemas)")
 	flagZip := flag.String("zip", "", "save here (if connect is specified), or load from here (if connect is empty)")
+	flagFormat := flag.String("format", "dot", "output format ("+strings.Join(rendererNames(), ", ")+")")
+	flagStyle := flag.String("style", "record", "table style of the dot format (record, html)")
+	flagColors := flag.String("colors", "", "comma separated list of table name prefix=color pairs, such as T_=lightblue,R_=lightyellow")
+	flagComments := flag.String("comments", "", "comma separated list of where to show the table and column comments (label, tooltip)")
+	flagCommentWidth := flag.Int("comment-width", 40, "maximal width of the comments in the labels (0: unlimited)")
+	flagCommentWrap := flag.Bool("comment-wrap", false, "wrap the long comments in the labels, instead of truncating them")
+	flagEdgeLabels := flag.Bool("edge-labels", false, "put the join counts and the sources into the edge labels")
+	flagDirected := flag.Bool("directed", false, "draw directed edges with crow's foot ends, from the foreign keys to the referenced keys")
+	flagColumns := flag.String("columns", "joined", "columns to show (joined, keys, all)")
+	flagUnlinked := flag.String("unlinked", "skip", "what to do with the tables without links (skip, show, cluster)")
+	flagGraph := flag.String("graph", "tables", "graph to draw: tables (the joins between the tables), calls (the dependencies between the program units), or usage (the tables used by the program units)")
+	flagFocus := flag.String("focus", "", "comma separated list of tables: show only the tables within -depth joins of them")
+	flagDepth := flag.Int("depth", 1, "number of the joins (and foreign keys, but not view lineages) from the -focus tables")
+	flagPath := flag.String("path", "", "two comma separated tables: show only the shortest join paths between them")
+	flagDynamic := flag.Bool("dynamic", false, "analyse the SQL built in strings for EXECUTE IMMEDIATE and OPEN ... FOR, too")
+	flagCRUD := flag.String("crud", "", "write the CRUD matrix of the sources and the tables ("+strings.Join(crudWriterNames(), ", ")+")")
+	flagCRUDOutput := flag.String("crud-output", "", "file to write the CRUD matrix to (if empty, it is written to the stdout, instead of the diagram)")
+	flagDiagnostics := flag.String("diagnostics", "", "write the statements the analysis failed on ("+strings.Join(diagnosticWriterNames(), ", ")+")")
+	flagDiagnosticsOutput := flag.String("diagnostics-output", "", "file to write the diagnostics to (if empty, they are written to the stderr)")
+	flagConfig := flag.String("config", "", "JSON config file with the table and source filters")
+	flagTables := flag.String("tables", "T_*,R_*", "comma separated list of table name patterns to include (glob, or /regexp/)")
+	flagExclTables := flag.String("exclude-tables", "", "comma separated list of table name patterns to exclude")
+	flagSources := flag.String("sources", "DB_*", "comma separated list of source name patterns to include")
+	flagExclSources := flag.String("exclude-sources", "", "comma separated list of source name patterns to exclude")
 	flag.Parse()
 
+	newRenderer, ok := renderers[*flagFormat]
+	if !ok {
+		log.Fatalf("unknown format %q (known: %s)", *flagFormat, strings.Join(rendererNames(), ", "))
+	}
+	if !(*flagStyle == "record" || *flagStyle == "html") {
+		log.Fatalf("unknown style %q (known: record, html)", *flagStyle)
+	}
+	if !(*flagColumns == "joined" || *flagColumns == "keys" || *flagColumns == "all") {
+		log.Fatalf("unknown columns %q (known: joined, keys, all)", *flagColumns)
+	}
+	if !(*flagUnlinked == "skip" || *flagUnlinked == "show" || *flagUnlinked == "cluster") {
+		log.Fatalf("unknown unlinked %q (known: skip, show, cluster)", *flagUnlinked)
+	}
+	if !(*flagGraph == "tables" || *flagGraph == "calls" || *flagGraph == "usage") {
+		log.Fatalf("unknown graph %q (known: tables, calls, usage)", *flagGraph)
+	}
+	if (*flagFocus != "" || *flagPath != "") && *flagGraph != "tables" {
+		log.Fatalf("-focus and -path need -graph=tables")
+	}
+	pathEnds := splitList(*flagPath)
+	if *flagPath != "" && len(pathEnds) != 2 {
+		log.Fatalf("-path needs two tables, got %q", *flagPath)
+	}
+	writeCRUD, ok := crudWriters[*flagCRUD]
+	if !ok && *flagCRUD != "" {
+		log.Fatalf("unknown CRUD format %q (known: %s)", *flagCRUD, strings.Join(crudWriterNames(), ", "))
+	}
+	writeDiagnostics, ok := diagnosticWriters[*flagDiagnostics]
+	if !ok && *flagDiagnostics != "" {
+		log.Fatalf("unknown diagnostics format %q (known: %s)", *flagDiagnostics, strings.Join(diagnosticWriterNames(), ", "))
+	}
+	colors, err := parseColors(*flagColors)
+	if err != nil {
+		log.Fatalf("error in colors: %v", err)
+	}
+	opts := renderOptions{Style: *flagStyle, Colors: colors,
+		CommentWidth: *flagCommentWidth, CommentWrap: *flagCommentWrap,
+		EdgeLabels: *flagEdgeLabels, Directed: *flagDirected}
+	for _, place := range splitList(*flagComments) {
+		switch place {
+		case "label":
+			opts.CommentLabel = true
+		case "tooltip":
+			opts.CommentTooltip = true
+		default:
+			log.Fatalf("unknown comment place %q (known: label, tooltip)", place)
+		}
+	}
+	rndr := newRenderer(opts)
+
+	cfg := config{
+		Tables:  filterConfig{Include: splitList(*flagTables), Exclude: splitList(*flagExclTables)},
+		Sources: filterConfig{Include: splitList(*flagSources), Exclude: splitList(*flagExclSources)},
+	}
+	if *flagConfig != "" {
+		var err error
+		if cfg, err = readConfig(*flagConfig); err != nil {
+			log.Fatalf("error reading config: %s", errgo.Details(err))
+		}
+		// the explicitly set flags override the config
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "tables":
+				cfg.Tables.Include = splitList(*flagTables)
+			case "exclude-tables":
+				cfg.Tables.Exclude = splitList(*flagExclTables)
+			case "sources":
+				cfg.Sources.Include = splitList(*flagSources)
+			case "exclude-sources":
+				cfg.Sources.Exclude = splitList(*flagExclSources)
+			}
+		})
+	}
+	tableFilter, err := newNameFilter(cfg.Tables)
+	if err != nil {
+		log.Fatalf("error in table filter: %s", errgo.Details(err))
+	}
+	sourceFilter, err := newNameFilter(cfg.Sources)
+	if err != nil {
+		log.Fatalf("error in source filter: %s", errgo.Details(err))
+	}
+
 	tables := make([]table, 0, 128)
 	sources := make([]source, 0, 128)
+	var deps []dependency // nil: parse them from the sources
 
 	if *flagDsn == "" {
 		if *flagZip == "" {
@@ -49,12 +157,12 @@ func main() {
 		}
 		defer zr.Close()
 		for _, f := range zr.File {
-			if !(f.Name == "tables.json" || f.Name == "sources.json") {
+			if !(f.Name == "tables.json" || f.Name == "sources.json" || f.Name == "dependencies.json") {
 				continue
 			}
 			rc, err := f.Open()
 			if err != nil {
-				log.Fatal("error opening %q: %v", f.Name, err)
+				log.Fatalf("error opening %q: %v", f.Name, err)
 			}
 			switch f.Name {
 			case "tables.json":
@@ -63,25 +171,41 @@ func main() {
 			case "sources.json":
 				err = json.NewDecoder(rc).Decode(&sources)
 				glog.Infof("read %d sources", len(sources))
+			case "dependencies.json":
+				err = json.NewDecoder(rc).Decode(&deps)
+				glog.Infof("read %d dependencies", len(deps))
 			}
 			rc.Close()
 			if err != nil {
 				log.Fatalf("error decoding from %q: %v", f.Name, err)
 			}
 		}
+		tables = filterTables(tables, tableFilter)
+		sources = filterSources(sources, sourceFilter)
 	} else {
-		db, err := sql.Open("goracle", *flagDsn)
+		newProvider, ok := providers[*flagDriver]
+		if !ok {
+			log.Fatalf("unknown driver %q (known: %s)", *flagDriver, strings.Join(providerNames(), ", "))
+		}
+		db, err := sql.Open(*flagDriver, *flagDsn)
 		if err != nil {
 			log.Fatalf("error connecting to %q: %v", *flagDsn, err)
 		}
-		tables, err := getTables(db)
-		if err != nil {
+		defer db.Close()
+		p := newProvider(db, providerOptions{Schemas: splitList(*flagSchemas), DBA: *flagDBA})
+		if tables, err = p.Tables(); err != nil {
 			log.Fatalf("error getting tables: %s", errgo.Details(err))
 		}
-		sources, err := getSources(db)
-		if err != nil {
+		if sources, err = p.Sources(); err != nil {
 			log.Fatalf("error getting sources: %s", errgo.Details(err))
 		}
+		if dp, ok := p.(dependencyProvider); ok && *flagGraph == "calls" {
+			if deps, err = dp.Dependencies(); err != nil {
+				log.Fatalf("error getting dependencies: %s", errgo.Details(err))
+			}
+		}
+		tables = filterTables(tables, tableFilter)
+		sources = filterSources(sources, sourceFilter)
 
 		// save
 		if *flagZip != "" {
@@ -96,176 +220,151 @@ func main() {
 
 			w, err := zw.Create("tables.json")
 			if err != nil {
-				log.Fatal("error creating tables.json: %v", err)
+				log.Fatalf("error creating tables.json: %v", err)
 			}
 			if err = json.NewEncoder(w).Encode(tables); err != nil {
-				log.Fatal("error encoding tables: %v", err)
+				log.Fatalf("error encoding tables: %v", err)
 			}
 
 			w, err = zw.Create("sources.json")
 			if err != nil {
-				log.Fatal("error creating sources.json")
+				log.Fatalf("error creating sources.json: %v", err)
 			}
 			if err = json.NewEncoder(w).Encode(sources); err != nil {
-				log.Fatal("error encoding sources: %v", err)
+				log.Fatalf("error encoding sources: %v", err)
+			}
+
+			if deps != nil {
+				if w, err = zw.Create("dependencies.json"); err != nil {
+					log.Fatalf("error creating dependencies.json: %v", err)
+				}
+				if err = json.NewEncoder(w).Encode(deps); err != nil {
+					log.Fatalf("error encoding dependencies: %v", err)
+				}
 			}
 		}
 	}
 
 	defer os.Stdout.Close()
-	if err := makeDot(os.Stdout, tables, sources); err != nil {
-		log.Fatalf("error creating dot: %v", err)
+	g := analyze(tables, sources, analyzeOptions{Columns: *flagColumns, Unlinked: *flagUnlinked, Dynamic: *flagDynamic})
+	// the diagnostics are written at the end of the run
+	reportDiagnostics := func() {
+		if writeDiagnostics == nil {
+			return
+		}
+		if *flagDiagnosticsOutput == "" {
+			if err := writeDiagnostics(os.Stderr, g.Diagnostics); err != nil {
+				log.Fatalf("error writing the diagnostics: %v", err)
+			}
+			return
+		}
+		fh, err := os.Create(*flagDiagnosticsOutput)
+		if err != nil {
+			log.Fatalf("error creating %q: %v", *flagDiagnosticsOutput, err)
+		}
+		err = writeDiagnostics(fh, g.Diagnostics)
+		if closeErr := fh.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("error writing the diagnostics to %q: %v", *flagDiagnosticsOutput, err)
+		}
+	}
+	if writeCRUD != nil {
+		if *flagCRUDOutput == "" {
+			if err := writeCRUD(os.Stdout, newCRUDMatrix(g.Usage)); err != nil {
+				log.Fatalf("error writing the CRUD matrix: %v", err)
+			}
+			reportDiagnostics()
+			return
+		}
+		fh, err := os.Create(*flagCRUDOutput)
+		if err != nil {
+			log.Fatalf("error creating %q: %v", *flagCRUDOutput, err)
+		}
+		err = writeCRUD(fh, newCRUDMatrix(g.Usage))
+		if closeErr := fh.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("error writing the CRUD matrix to %q: %v", *flagCRUDOutput, err)
+		}
+	}
+	if *flagFocus != "" {
+		if err := g.focus(splitList(*flagFocus), *flagDepth); err != nil {
+			log.Fatalf("error in focus: %s", errgo.Details(err))
+		}
 	}
+	if len(pathEnds) == 2 {
+		if err := g.shortestPaths(pathEnds[0], pathEnds[1]); err != nil {
+			log.Fatalf("error in path: %s", errgo.Details(err))
+		}
+	}
+	switch *flagGraph {
+	case "calls":
+		err = rndr.RenderDeps(os.Stdout, newCallGraph(sources, deps))
+	case "usage":
+		err = rndr.RenderDeps(os.Stdout, newUsageGraph(g.Usage, tables))
+	default:
+		err = rndr.Render(os.Stdout, g)
+	}
+	if err != nil {
+		log.Fatalf("error rendering %s: %v", *flagFormat, err)
+	}
+	reportDiagnostics()
 }
 
+// table is a table or a view. The Text of a view is its defining query.
 type table struct {
+	Owner         string `json:",omitempty"`
 	Name, Comment string
 	Fields        []field
+	Constraints   []constraint `json:",omitempty"`
+	View          bool         `json:",omitempty"`
+	Text          string       `json:",omitempty"`
 }
 
-type field struct {
-	Name, Type, Comment string
+// qualifiedName returns OWNER.NAME, or just NAME if the owner is empty.
+func qualifiedName(owner, name string) string {
+	if owner == "" {
+		return name
+	}
+	return owner + "." + name
 }
 
-type source struct {
+// constraint is a declared primary key (P), unique key (U)
+// or foreign key (R) constraint.
+type constraint struct {
 	Name, Type string
-	Code       string
-}
-
-func getSources(db *sql.DB) ([]source, error) {
-	sources := make([]source, 0, 64)
-	qry := `SELECT name, type, text FROM user_source
-			  WHERE name LIKE 'DB_%'
-	          ORDER BY name, type, line`
-	rows, err := db.Query(qry)
-	if err != nil {
-		return nil, errgo.Notef(err, qry)
-	}
-	var s, t source
-	lines := bytes.NewBuffer(make([]byte, 0, 1<<20))
-	for rows.Next() {
-		var line string
-		if err = rows.Scan(&t.Name, &t.Type, &line); err != nil {
-			log.Printf("error scanning source: %v", err)
-			continue
-		}
-		if s.Name != t.Name {
-			if s.Name != "" {
-				s.Code = lines.String()
-				sources = append(sources, s)
-			}
-			s = t
-			lines.Reset()
-		}
-		lines.WriteString(line)
-	}
-	if t.Name != "" {
-		t.Code = lines.String()
-		sources = append(sources, t)
-	}
-	if err = rows.Err(); err != nil && err != io.EOF {
-		return sources, errgo.Mask(err)
-	}
-	return sources, nil
+	Fields     []string
+	RefOwner   string   `json:",omitempty"`
+	RefTable   string   `json:",omitempty"`
+	RefFields  []string `json:",omitempty"`
 }
 
-func getTables(db *sql.DB) ([]table, error) {
-	tableNames, err := getTableNames(db)
-	if err != nil {
-		return nil, errgo.Notef(err, "table names")
-	}
-
-	qry := `SELECT A.table_name, A.column_name, A.data_type, NVL(B.comments, ' ')
-      FROM user_col_comments B, user_tab_cols A
-        WHERE B.column_name(+) = A.column_name AND
-              B.table_name(+) = A.table_name AND
-			  SUBSTR(A.table_name, 1, 2) IN ('T_', 'R_')
-	    ORDER BY A.table_name, A.column_id`
-	rows, err := db.Query(qry)
-	if err != nil {
-		return nil, errgo.Notef(err, qry)
-	}
-	tables := make([]table, 0, len(tableNames))
-	var t table
-	var act, prev string
-	for rows.Next() {
-		var f field
-		if err = rows.Scan(&act, &f.Name, &f.Type, &f.Comment); err != nil {
-			glog.Warningf("error scanning field: %v", err)
+// foreignLinks returns the links declared by the foreign keys of the table,
+// from the foreign key (A) to the referenced key (B).
+// A self-referencing foreign key links the table to itself.
+func (t table) foreignLinks() []link {
+	var links []link
+	for _, c := range t.Constraints {
+		if c.Type != "R" {
 			continue
 		}
-		if prev != act {
-			if prev != "" {
-				tables = append(tables, t)
-			}
-			prev = act
-			t.Name = act
-			t.Comment = tableNames[act]
-			t.Fields = make([]field, 0, 8)
-		}
-		glog.V(2).Infof("field %s", f)
-		t.Fields = append(t.Fields, f)
-	}
-	tables = append(tables, t)
-	if err = rows.Err(); err != nil && err != io.EOF {
-		return tables, errgo.Mask(err)
+		links = append(links, pairLink(
+			linkField{Owner: t.Owner, Table: t.Name, Fields: c.Fields},
+			linkField{Owner: c.RefOwner, Table: c.RefTable, Fields: c.RefFields},
+		))
 	}
-	return tables, nil
+	return links
 }
 
-func getTableFields(db *sql.DB, tbl string) ([]field, error) {
-	qry := `SELECT A.column_name, A.data_type, NVL(B.comments, ' ')
-      FROM user_col_comments B, user_tab_cols A
-        WHERE B.column_name(+) = A.column_name AND
-              B.table_name(+) = A.table_name AND
-              A.table_name = :1`
-	rows, err := db.Query(qry, tbl)
-	if err != nil {
-		return nil, errgo.Notef(err, qry)
-	}
-	fields := make([]field, 0, 8)
-	for rows.Next() {
-		var f field
-		if err = rows.Scan(&f.Name, &f.Type, &f.Comment); err != nil {
-			log.Printf("error scanning field: %v", err)
-			continue
-		}
-		if f.Comment == " " {
-			f.Comment = ""
-		}
-		glog.V(1).Infof("tbl %s field %s", tbl, f.Name)
-		fields = append(fields, f)
-	}
-	if err = rows.Err(); err != nil && err != io.EOF {
-		return fields, errgo.Mask(err)
-	}
-	return fields, nil
+type field struct {
+	Name, Type, Comment string
 }
 
-func getTableNames(db *sql.DB) (map[string]string, error) {
-	qry := `SELECT A.table_name, NVL(B.comments, ' ')
-              FROM user_tab_comments B, user_tables A
-              WHERE B.table_name(+) = A.table_name AND
-                    (A.table_name LIKE 'T_%' OR A.table_name LIKE 'R_%')`
-	rows, err := db.Query(qry)
-	if err != nil {
-		return nil, errgo.Notef(err, "query %q", qry)
-	}
-	tables := make(map[string]string, 128)
-	for rows.Next() {
-		var name, comment string
-		if err = rows.Scan(&name, &comment); err != nil {
-			glog.Warningf("error scanning table name: %v", err)
-			continue
-		}
-		if comment == " " {
-			comment = ""
-		}
-		glog.V(1).Infof("table %s (%q)", name, comment)
-		tables[name] = comment
-	}
-	if err := rows.Err(); err != nil && err != io.EOF {
-		return tables, errgo.Notef(err, "rows")
-	}
-	return tables, nil
+type source struct {
+	Owner      string `json:",omitempty"`
+	Name, Type string
+	Code       string
 }
@@ -0,0 +1,46 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForeignLinks(t *testing.T) {
+	tbl := table{Owner: "S", Name: "T_NOTE",
+		Constraints: []constraint{
+			{Name: "T_NOTE_PK", Type: "P", Fields: []string{"ID"}},
+			{Name: "T_NOTE_LINE_FK", Type: "R", Fields: []string{"LINE_NO", "CONTRACT_ID"},
+				RefOwner: "S", RefTable: "T_LINE", RefFields: []string{"NO", "CONTRACT_ID"}},
+			{Name: "T_NOTE_PARENT_FK", Type: "R", Fields: []string{"PARENT_ID"},
+				RefOwner: "S", RefTable: "T_NOTE", RefFields: []string{"ID"}},
+			{Name: "T_NOTE_USER_FK", Type: "R", Fields: []string{"USER_ID"},
+				RefOwner: "A", RefTable: "T_USER", RefFields: []string{"ID"}},
+		}}
+	awaited := []link{
+		{A: linkField{Owner: "S", Table: "T_NOTE", Fields: []string{"CONTRACT_ID", "LINE_NO"}},
+			B: linkField{Owner: "S", Table: "T_LINE", Fields: []string{"CONTRACT_ID", "NO"}}},
+		{A: linkField{Owner: "S", Table: "T_NOTE", Fields: []string{"PARENT_ID"}},
+			B: linkField{Owner: "S", Table: "T_NOTE", Fields: []string{"ID"}}},
+		{A: linkField{Owner: "S", Table: "T_NOTE", Fields: []string{"USER_ID"}},
+			B: linkField{Owner: "A", Table: "T_USER", Fields: []string{"ID"}}},
+	}
+	if got := tbl.foreignLinks(); !reflect.DeepEqual(got, awaited) {
+		t.Errorf("got %+v, awaited %+v.", got, awaited)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	renderers["mermaid"] = func(opts renderOptions) renderer { return mermaidRenderer{opts} }
+}
+
+// mermaidRenderer renders the graph as a Mermaid erDiagram.
+// Mermaid has no clusters, so the schemas are part of the entity names,
+// and only the column comments can be shown (with -comments=label).
+type mermaidRenderer struct {
+	renderOptions
+}
+
+func (r mermaidRenderer) Render(w io.Writer, g *graph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("erDiagram\n")
+	for _, t := range g.Tables {
+		fmt.Fprintf(bw, "    %s {\n", mermaidID(t.Owner, t.Name))
+		for _, f := range t.Used {
+			fmt.Fprintf(bw, "        %s %s", mermaidType(f.Type), simpleID(f.Name))
+			if lines := r.commentLines(f.Comment); r.CommentLabel && len(lines) != 0 {
+				fmt.Fprintf(bw, " %q", strings.Replace(strings.Join(lines, " "), `"`, "'", -1))
+			}
+			bw.WriteByte('\n')
+		}
+		bw.WriteString("    }\n")
+	}
+	for _, e := range g.Edges {
+		rel := r.erRelation(e)
+		fmt.Fprintf(bw, "    %s %s %s : %q\n",
+			mermaidID(e.A.Owner, e.A.Table), rel, mermaidID(e.B.Owner, e.B.Table),
+			r.edgeLabel(e))
+	}
+	return bw.Flush()
+}
+
+func mermaidID(owner, name string) string {
+	if owner != "" {
+		name = owner + "__" + name
+	}
+	return simpleID(name)
+}
+
+// mermaidType returns the type as a Mermaid attribute type: NUMBER(10,2) is NUMBER(10_2).
+func mermaidType(typ string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '(' || r == ')' || r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, typ)
+}
+
+// mermaidShapes are the opening and closing node brackets
+// of the object kinds in the dependency graphs.
+var mermaidShapes = map[string][2]string{"PACKAGE": {"[[", "]]"}, "TABLE": {"[(", ")]"}, "VIEW": {"[(", ")]"}}
+
+// RenderDeps renders the dependency graph as a flowchart,
+// with the cycles in the "cycle" class.
+func (r mermaidRenderer) RenderDeps(w io.Writer, g *depGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("flowchart LR\n")
+	var cycleNodes []string
+	for _, n := range g.Nodes {
+		shape, ok := mermaidShapes[n.Kind]
+		if !ok {
+			shape = [2]string{"[", "]"}
+		}
+		id := mermaidID(n.Owner, n.Name)
+		fmt.Fprintf(bw, "    %s%s%q%s\n", id, shape[0], qualifiedName(n.Owner, n.Name), shape[1])
+		if n.Cycle != 0 {
+			cycleNodes = append(cycleNodes, id)
+		}
+	}
+	var cycleEdges []string
+	for i, e := range g.Edges {
+		from, to := g.Nodes[e.From], g.Nodes[e.To]
+		arrow := "-->"
+		if e.Label != "" {
+			arrow += "|" + strconv.Quote(e.Label) + "|"
+		}
+		fmt.Fprintf(bw, "    %s %s %s\n", mermaidID(from.Owner, from.Name), arrow, mermaidID(to.Owner, to.Name))
+		if e.Cycle {
+			cycleEdges = append(cycleEdges, strconv.Itoa(i))
+		}
+	}
+	if len(cycleNodes) != 0 {
+		fmt.Fprintf(bw, "    classDef cycle fill:%s,stroke:red\n    class %s cycle\n", cycleColor, strings.Join(cycleNodes, ","))
+	}
+	if len(cycleEdges) != 0 {
+		fmt.Fprintf(bw, "    linkStyle %s stroke:red\n", strings.Join(cycleEdges, ","))
+	}
+	return bw.Flush()
+}
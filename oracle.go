@@ -0,0 +1,323 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	_ "github.com/tgulacsi/goracle/godrv"
+	"gopkg.in/errgo.v1"
+)
+
+func init() {
+	providers["goracle"] = func(db *sql.DB, opts providerOptions) schemaProvider {
+		p := oracleProvider{db: db, owners: opts.Schemas, dict: "all"}
+		if opts.DBA {
+			p.dict = "dba"
+		}
+		return p
+	}
+}
+
+// oracleProvider reads the schema from the Oracle data dictionary.
+//
+// Without owners, only the user's own schema is read, and the owners are
+// left empty in the model. With owners, the ALL_* (or DBA_*) views are read
+// for all the given schemas.
+type oracleProvider struct {
+	db     *sql.DB
+	owners []string
+	dict   string // all or dba
+}
+
+// ownerCond returns the condition restricting alias.owner to the owners,
+// and the bind parameters for it.
+func (p oracleProvider) ownerCond(alias string) (string, []interface{}) {
+	if len(p.owners) == 0 {
+		return alias + ".owner = USER", nil
+	}
+	params := make([]interface{}, len(p.owners))
+	marks := make([]string, len(p.owners))
+	for i, owner := range p.owners {
+		params[i] = strings.ToUpper(owner)
+		marks[i] = ":" + strconv.Itoa(i+1)
+	}
+	return alias + ".owner IN (" + strings.Join(marks, ", ") + ")", params
+}
+
+// owner returns the owner as stored in the model.
+func (p oracleProvider) owner(owner string) string {
+	if len(p.owners) == 0 {
+		return ""
+	}
+	return owner
+}
+
+func (p oracleProvider) Sources() ([]source, error) {
+	sources := make([]source, 0, 64)
+	cond, params := p.ownerCond("A")
+	qry := `SELECT A.owner, A.name, A.type, A.text FROM ` + p.dict + `_source A
+	          WHERE ` + cond + `
+	          ORDER BY A.owner, A.name, A.type, A.line`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	var s, t source
+	lines := bytes.NewBuffer(make([]byte, 0, 1<<20))
+	for rows.Next() {
+		var line string
+		if err = rows.Scan(&t.Owner, &t.Name, &t.Type, &line); err != nil {
+			glog.Warningf("error scanning source: %v", err)
+			continue
+		}
+		t.Owner = p.owner(t.Owner)
+		// the package (type) spec and body are separate sources
+		if s.Owner != t.Owner || s.Name != t.Name || s.Type != t.Type {
+			if s.Name != "" {
+				s.Code = lines.String()
+				sources = append(sources, s)
+			}
+			s = t
+			lines.Reset()
+		}
+		lines.WriteString(line)
+	}
+	if t.Name != "" {
+		t.Code = lines.String()
+		sources = append(sources, t)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return sources, errgo.Mask(err)
+	}
+	return sources, nil
+}
+
+func (p oracleProvider) Dependencies() ([]dependency, error) {
+	cond, params := p.ownerCond("A")
+	if len(p.owners) == 0 {
+		// the referenced owners are left empty, too,
+		// so the references to the other schemas would look local
+		cond += " AND A.referenced_owner = A.owner"
+	}
+	qry := `SELECT A.owner, A.name, A.type, A.referenced_owner, A.referenced_name, A.referenced_type
+	          FROM ` + p.dict + `_dependencies A
+	          WHERE ` + cond + ` AND
+	                A.referenced_type IN ('PACKAGE', 'PROCEDURE', 'FUNCTION', 'TYPE')
+	          ORDER BY A.owner, A.name, A.type, A.referenced_owner, A.referenced_name`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	deps := make([]dependency, 0, 256)
+	for rows.Next() {
+		var d dependency
+		if err = rows.Scan(&d.Owner, &d.Name, &d.Type, &d.RefOwner, &d.RefName, &d.RefType); err != nil {
+			glog.Warningf("error scanning dependency: %v", err)
+			continue
+		}
+		d.Owner, d.RefOwner = p.owner(d.Owner), p.owner(d.RefOwner)
+		deps = append(deps, d)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return deps, errgo.Mask(err)
+	}
+	return deps, nil
+}
+
+func (p oracleProvider) Tables() ([]table, error) {
+	tableNames, err := p.TableNames()
+	if err != nil {
+		return nil, errgo.Notef(err, "table names")
+	}
+	views, err := p.views()
+	if err != nil {
+		return nil, errgo.Notef(err, "views")
+	}
+
+	cond, params := p.ownerCond("A")
+	qry := `SELECT A.owner, A.table_name, A.column_name, A.data_type, NVL(B.comments, ' ')
+      FROM ` + p.dict + `_col_comments B, ` + p.dict + `_tab_cols A,
+           (SELECT owner, table_name FROM ` + p.dict + `_tables
+            UNION ALL
+            SELECT owner, view_name FROM ` + p.dict + `_views) C
+        WHERE B.owner(+) = A.owner AND
+              B.table_name(+) = A.table_name AND
+              B.column_name(+) = A.column_name AND
+              C.owner = A.owner AND C.table_name = A.table_name AND
+              ` + cond + `
+	    ORDER BY A.owner, A.table_name, A.column_id`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	tables := make([]table, 0, len(tableNames))
+	var owner, name, prev string
+	for rows.Next() {
+		var f field
+		if err = rows.Scan(&owner, &name, &f.Name, &f.Type, &f.Comment); err != nil {
+			glog.Warningf("error scanning field: %v", err)
+			continue
+		}
+		if f.Comment == " " {
+			f.Comment = ""
+		}
+		owner = p.owner(owner)
+		if act := qualifiedName(owner, name); prev != act {
+			prev = act
+			t, ok := views[act]
+			if !ok {
+				t = table{Owner: owner, Name: name, Comment: tableNames[act]}
+			}
+			t.Fields = make([]field, 0, 8)
+			tables = append(tables, t)
+		}
+		glog.V(2).Infof("field %s", f)
+		t := &tables[len(tables)-1]
+		t.Fields = append(t.Fields, f)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return tables, errgo.Mask(err)
+	}
+
+	constraints, err := p.constraints()
+	if err != nil {
+		return tables, errgo.Notef(err, "constraints")
+	}
+	for i, t := range tables {
+		tables[i].Constraints = constraints[qualifiedName(t.Owner, t.Name)]
+	}
+	return tables, nil
+}
+
+// constraints returns the primary key, unique key and foreign key
+// constraints, per qualified table name.
+func (p oracleProvider) constraints() (map[string][]constraint, error) {
+	cond, params := p.ownerCond("A")
+	qry := `SELECT A.owner, A.table_name, A.constraint_name, A.constraint_type, B.column_name,
+	           NVL(C.owner, ' '), NVL(C.table_name, ' '), NVL(D.column_name, ' ')
+	      FROM ` + p.dict + `_constraints A, ` + p.dict + `_cons_columns B,
+	           ` + p.dict + `_constraints C, ` + p.dict + `_cons_columns D
+	      WHERE A.constraint_type IN ('P', 'U', 'R') AND ` + cond + ` AND
+	            B.owner = A.owner AND B.constraint_name = A.constraint_name AND
+	            C.owner(+) = A.r_owner AND C.constraint_name(+) = A.r_constraint_name AND
+	            D.owner(+) = C.owner AND D.constraint_name(+) = C.constraint_name AND
+	            D.position(+) = B.position
+	    ORDER BY A.owner, A.table_name, A.constraint_name, B.position`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	constraints := make(map[string][]constraint, 128)
+	var act, prev string
+	for rows.Next() {
+		var owner, tbl, name, typ, fld, refOwner, refTbl, refFld string
+		if err = rows.Scan(&owner, &tbl, &name, &typ, &fld, &refOwner, &refTbl, &refFld); err != nil {
+			glog.Warningf("error scanning constraint: %v", err)
+			continue
+		}
+		tbl = qualifiedName(p.owner(owner), tbl)
+		if act = tbl + "." + name; act != prev {
+			prev = act
+			constraints[tbl] = append(constraints[tbl],
+				constraint{Name: name, Type: typ, Fields: make([]string, 0, 2)})
+		}
+		cs := constraints[tbl]
+		k := &cs[len(cs)-1]
+		k.Fields = append(k.Fields, fld)
+		if k.Type == "R" {
+			k.RefOwner, k.RefTable = p.owner(refOwner), refTbl
+			k.RefFields = append(k.RefFields, refFld)
+		}
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return constraints, errgo.Mask(err)
+	}
+	return constraints, nil
+}
+
+// views returns the views, with their comments and texts, per qualified name.
+func (p oracleProvider) views() (map[string]table, error) {
+	cond, params := p.ownerCond("A")
+	qry := `SELECT A.owner, A.view_name, NVL(B.comments, ' '), A.text
+	          FROM ` + p.dict + `_tab_comments B, ` + p.dict + `_views A
+	          WHERE B.owner(+) = A.owner AND B.table_name(+) = A.view_name AND
+	                ` + cond
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	views := make(map[string]table, 32)
+	for rows.Next() {
+		t := table{View: true}
+		if err = rows.Scan(&t.Owner, &t.Name, &t.Comment, &t.Text); err != nil {
+			glog.Warningf("error scanning view: %v", err)
+			continue
+		}
+		if t.Comment == " " {
+			t.Comment = ""
+		}
+		t.Owner = p.owner(t.Owner)
+		glog.V(1).Infof("view %s (%q)", t.Name, t.Comment)
+		views[qualifiedName(t.Owner, t.Name)] = t
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return views, errgo.Notef(err, "rows")
+	}
+	return views, nil
+}
+
+func (p oracleProvider) TableNames() (map[string]string, error) {
+	cond, params := p.ownerCond("A")
+	qry := `SELECT A.owner, A.table_name, NVL(B.comments, ' ')
+              FROM ` + p.dict + `_tab_comments B, ` + p.dict + `_tables A
+              WHERE B.owner(+) = A.owner AND B.table_name(+) = A.table_name AND
+                    ` + cond
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	tables := make(map[string]string, 128)
+	for rows.Next() {
+		var owner, name, comment string
+		if err = rows.Scan(&owner, &name, &comment); err != nil {
+			glog.Warningf("error scanning table name: %v", err)
+			continue
+		}
+		if comment == " " {
+			comment = ""
+		}
+		name = qualifiedName(p.owner(owner), name)
+		glog.V(1).Infof("table %s (%q)", name, comment)
+		tables[name] = comment
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return tables, errgo.Notef(err, "rows")
+	}
+	return tables, nil
+}
@@ -17,257 +17,643 @@ limitations under the License.
 package main
 
 import (
-	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
 )
 
-var rFrom = regexp.MustCompile(`\sFROM\s`)
-var rWhere = regexp.MustCompile(`\sWHERE\s`)
-
+// linkField is one side of a link: the joined columns of a table.
 type linkField struct {
-	Table, Field string
+	Owner  string
+	Table  string
+	Fields []string
+}
+
+// qualified returns the qualified table name of the field.
+func (f linkField) qualified() string {
+	return qualifiedName(f.Owner, f.Table)
 }
 
+// link is a relationship between two tables, on one or more column pairs:
+// A.Fields[i] = B.Fields[i].
 type link struct {
 	A, B linkField
 }
 
-// selectGetLinks parses code (which should be a SELECT statement only)
-// and returns the table1.field1 = table2.field2 pairs.
-func selectGetLinks(code string) []link {
-	fromI := findRe(code, rFrom)
-	if fromI < 0 {
-		glog.V(1).Infof("cannot find FROM in %q", code)
-		return nil
+// key returns the identity of the link, to be used as a map key.
+func (lnk link) key() string {
+	return lnk.A.qualified() + "(" + strings.Join(lnk.A.Fields, ",") + ")=" +
+		lnk.B.qualified() + "(" + strings.Join(lnk.B.Fields, ",") + ")"
+}
+
+// newLink returns the link between a and b, ordered by the qualified table names,
+// with the column pairs ordered by the columns of A.
+// Returns false if a and b are in the same table.
+func newLink(a, b linkField) (link, bool) {
+	switch qa, qb := a.qualified(), b.qualified(); {
+	case qa < qb:
+		return pairLink(a, b), true
+	case qa > qb:
+		return pairLink(b, a), true
 	}
-	fromI += 6
-	glog.V(2).Infof("fromI=%d => code[fromI:]=%q", fromI, code[fromI:])
-	whereI := findRe(code[fromI:], rWhere)
-	if whereI < 0 {
-		glog.V(1).Infof("cannot find WHERE in %q", code[fromI:])
-		return nil
+	return link{}, false
+}
+
+// pairLink returns the link from a to b, with the column pairs ordered by the columns of A.
+func pairLink(a, b linkField) link {
+	lnk := link{A: a, B: b}
+	pairs := make([][2]string, 0, len(lnk.A.Fields))
+	for i, f := range lnk.A.Fields {
+		if i < len(lnk.B.Fields) {
+			pairs = append(pairs, [2]string{f, lnk.B.Fields[i]})
+		}
 	}
-	glog.V(2).Infof("fromI=%d whereI=%d (%q)", fromI, fromI+whereI, code)
-	from := code[fromI : fromI+whereI]
-	whereI += fromI + 7
-	where := code[whereI:]
-	glog.V(2).Infof("fromI=%d whereI=%d => from=%q where=%q", fromI, whereI, from, where)
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	lnk.A.Fields, lnk.B.Fields = make([]string, len(pairs)), make([]string, len(pairs))
+	for i, p := range pairs {
+		lnk.A.Fields[i], lnk.B.Fields[i] = p[0], p[1]
+	}
+	return lnk
+}
 
-	tables := fromTables(from)
-	glog.V(1).Infof("tables=%#v", tables)
+// tableRef is a table reference in a FROM clause.
+// Owner, Name and Alias are normalized (see token.name), Raw is as written.
+// Inline views and references to factored subqueries (WITH) have
+// no Name, but the Sub query.
+type tableRef struct {
+	Owner, Name, Alias string
+	Raw                string
+	Sub                *query // inline view, or factored subquery
+	CTE                bool   // Sub is a factored subquery, defined in a WITH clause
+}
+
+// colRef is a alias.column reference in a condition.
+type colRef struct {
+	Alias, Column string
+}
+
+// selectColumn is an item of the select list: its (output) name, and the
+// column it refers to, if it is a plain column reference.
+// A * or alias.* item has the name "*".
+type selectColumn struct {
+	Name string
+	Ref  *colRef
+}
 
-	equations := whereEquations(where, tables)
-	if len(equations) == 0 {
-		glog.V(1).Infof("no eqs in %q (where=%q tables=%q)", code, where, tables)
+// query is the relevant part of one SELECT:
+// the tables of the FROM clause and the equations of the WHERE and ON conditions,
+// the subqueries of the conditions and the select list, and the factored subqueries.
+type query struct {
+	Tables    []tableRef
+	Equations [][2]colRef
+	Columns   []selectColumn
+	Next      *query     // UNION, INTERSECT, MINUS
+	Outer     []tableRef // the tables of the enclosing statement, for the correlated conditions
+	With      []*query   // WITH name AS (subquery)
+	Sub       []*query   // the subqueries of the conditions and the select list
+}
+
+// scope is what a query sees from the enclosing statements.
+type scope struct {
+	outer []tableRef
+	ctes  map[string]*query
+}
+
+// inner returns the scope of the subqueries of q.
+func (sc scope) inner(q *query) scope {
+	outer := make([]tableRef, 0, len(sc.outer)+len(q.Tables))
+	return scope{outer: append(append(outer, sc.outer...), q.Tables...), ctes: sc.ctes}
+}
+
+// selectGetLinks parses code (which should be a SELECT statement only)
+// and returns the table1.(fields) = table2.(fields) links,
+// from the subqueries, too.
+func selectGetLinks(code string) []link {
+	var links []link
+	parseQuery(significant(lex(code))).walk(func(q *query) {
+		links = append(links, q.links()...)
+	})
+	return links
+}
+
+// walk calls f for q, the UNIONed queries, and all the subqueries, recursively.
+// The factored subqueries are visited once, where they are defined.
+func (q *query) walk(f func(*query)) {
+	for ; q != nil; q = q.Next {
+		f(q)
+		for _, w := range q.With {
+			w.walk(f)
+		}
+		for _, t := range q.Tables {
+			if t.Sub != nil && !t.CTE {
+				t.Sub.walk(f)
+			}
+		}
+		for _, s := range q.Sub {
+			s.walk(f)
+		}
+	}
+}
+
+// links returns the links of the equations, which join two different tables.
+// The columns of the inline views and the factored subqueries are resolved
+// to the columns of their tables.
+// The equations between the same two table references are collected into
+// one link, so a composite key join is one link with several column pairs.
+func (q *query) links() []link {
+	if len(q.Equations) == 0 {
+		glog.V(1).Infof("no eqs in %v", q.Tables)
 		return nil
 	}
-	links := make([]link, 0, len(equations))
-	var lnkScratch [2]linkField
-	for _, eq := range equations {
-		var lnk link
+	var pairs [][2]tableRef // the table pairs, in order of appearance
+	sides := make(map[[2]tableRef]*[2]linkField, len(q.Equations))
+Eqs:
+	for _, eq := range q.Equations {
+		var refs [2]tableRef
+		var cols [2]string
 		for i, fld := range eq {
-			j := strings.IndexByte(fld, '.')
-			tbl, ok := tables[fld[:j]]
-			if !ok {
-				glog.V(1).Infof("cannot find table for field %q.", fld)
-				goto Next
+			var ok bool
+			if refs[i], cols[i], ok = q.resolveColumn(fld, 0); !ok {
+				glog.V(1).Infof("cannot find table for field %v.", fld)
+				continue Eqs
 			}
-			lnkScratch[i] = linkField{Table: strings.ToUpper(tbl), Field: strings.ToUpper(fld[j+1:])}
+			refs[i].Sub = nil
 		}
-		switch {
-		case lnkScratch[0].Table < lnkScratch[1].Table:
-			lnk = link{A: lnkScratch[0], B: lnkScratch[1]}
-		case lnkScratch[0].Table > lnkScratch[1].Table:
-			lnk = link{A: lnkScratch[1], B: lnkScratch[0]}
-		default: // equals
-			continue
+		if refs[0].Alias > refs[1].Alias {
+			refs[0], refs[1], cols[0], cols[1] = refs[1], refs[0], cols[1], cols[0]
+		}
+		lf := sides[refs]
+		if lf == nil {
+			lf = &[2]linkField{
+				{Owner: refs[0].Owner, Table: refs[0].Name},
+				{Owner: refs[1].Owner, Table: refs[1].Name},
+			}
+			sides[refs] = lf
+			pairs = append(pairs, refs)
+		}
+		for i := range lf[0].Fields {
+			if lf[0].Fields[i] == cols[0] && lf[1].Fields[i] == cols[1] {
+				continue Eqs
+			}
+		}
+		lf[0].Fields = append(lf[0].Fields, cols[0])
+		lf[1].Fields = append(lf[1].Fields, cols[1])
+	}
+	links := make([]link, 0, len(pairs))
+	for _, pair := range pairs {
+		lf := sides[pair]
+		if lnk, ok := newLink(lf[0], lf[1]); ok {
+			links = append(links, lnk)
 		}
-		links = append(links, lnk)
-	Next:
 	}
 	return links
 }
 
-var rField = regexp.MustCompile("\b[A-Za-z][A-Za-z0-9_]*[.][A-Za-z][A-Za-z0-9_]*\b")
+// maxDepth limits the resolution of the columns through the subqueries.
+const maxDepth = 16
 
-// where Equations returns the table1.field1 = table2.field2 pairs
-func whereEquations(where string, tables map[string]string) [][2]string {
-	keys := make([]string, 0, len(tables))
-	for k := range tables {
-		keys = append(keys, k)
+// resolveColumn returns the table and the column c refers to,
+// through the inline views and the factored subqueries.
+// An unqualified column is resolved only if there is only one table
+// (see catalog.qualify for resolving them by the known columns).
+func (q *query) resolveColumn(c colRef, depth int) (tableRef, string, bool) {
+	if depth > maxDepth {
+		return tableRef{}, "", false
+	}
+	t, ok := q.lookup(c.Alias)
+	if !ok {
+		return t, "", false
+	}
+	if t.Sub == nil {
+		return t, c.Column, t.Name != ""
 	}
+	return t.Sub.output(c.Column, depth+1)
+}
 
-	rField := regexp.MustCompile("(?:(?i)(?:" + strings.Join(keys, "|") + "))[.][A-Za-z][A-Za-z0-9_]*\\b")
-	glog.V(2).Infof("rField=%s", rField)
-	locs := rField.FindAllStringIndex(where, -1)
-	if len(locs) == 0 {
-		glog.V(1).Infof("cannot find fields in %q", where)
-		return nil
+// lookup returns the table of the alias: the query's own tables
+// shadow the tables of the enclosing queries.
+func (q *query) lookup(alias string) (tableRef, bool) {
+	if alias == "" {
+		if len(q.Tables) == 1 {
+			return q.Tables[0], true
+		}
+		return tableRef{}, false
 	}
-	eqs := make([][2]string, 0, len(locs)/2)
-	for i := 0; i < len(locs)-1; i++ {
-		act, nxt := locs[i], locs[i+1]
-		i := strings.IndexByte(where[act[1]:nxt[0]], '=')
-		if i < 0 {
-			i = strings.Index(where[act[1]:nxt[0]], "LIKE")
-			if i < 0 {
-				glog.V(1).Infof("cannot find . in %q", where[act[1]:nxt[0]])
-				continue
+	for _, t := range q.Tables {
+		if t.Alias == alias {
+			return t, true
+		}
+	}
+	for i := len(q.Outer) - 1; i >= 0; i-- {
+		if q.Outer[i].Alias == alias {
+			return q.Outer[i], true
+		}
+	}
+	return tableRef{}, false
+}
+
+// output returns the table and the column of the output column
+// of the (sub)query with the given name.
+func (q *query) output(name string, depth int) (tableRef, string, bool) {
+	for _, c := range q.Columns {
+		if c.Name == name && c.Ref != nil {
+			return q.resolveColumn(*c.Ref, depth)
+		}
+	}
+	for _, c := range q.Columns {
+		if c.Name == "*" {
+			if t, col, ok := q.resolveColumn(colRef{Alias: c.Ref.Alias, Column: name}, depth); ok {
+				return t, col, ok
 			}
 		}
-		i += act[1]
-		eqs = append(eqs, [2]string{where[act[0]:act[1]], where[nxt[0]:nxt[1]]})
-		i++
 	}
-	return eqs
+	return tableRef{}, "", false
+}
+
+// clauseKeywords start a new clause of a query, when not inside brackets.
+var clauseKeywords = map[string]bool{
+	"SELECT": true, "INTO": true, "FROM": true, "WHERE": true,
+	"GROUP": true, "HAVING": true, "ORDER": true, "CONNECT": true, "START": true,
+	"FOR":  true, // FOR UPDATE
+	"BULK": true, // BULK COLLECT INTO
 }
 
-// fromTables returns the sign->table mappings from the from string
-func fromTables(from string) map[string]string {
-	tables := make(map[string]string, 2)
-	for _, part := range strings.Split(from, ",") {
-		part = strings.TrimSpace(part)
-		if len(part) == 0 || strings.IndexAny(part, "()") >= 0 {
+// parseQuery parses the tokens of a SELECT statement.
+// It returns nil if there is no FROM clause.
+func parseQuery(toks []token) *query {
+	return parseQueryScope(toks, scope{})
+}
+
+// parseQueryScope parses the tokens of a (sub)query, seeing sc.
+func parseQueryScope(toks []token, sc scope) *query {
+	var with []*query
+	if len(toks) > 0 && toks[0].is("WITH") {
+		with, sc, toks = parseWith(toks[1:], sc)
+	}
+	clauses := make(map[string][]token, 4)
+	var act string
+	var next *query
+	depth := 0
+Loop:
+	for i, t := range toks {
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && t.Kind == tokKeyword && (t.is("UNION") || t.is("INTERSECT") || t.is("MINUS")):
+			next = parseQueryScope(toks[i+1:], sc)
+			break Loop
+		case depth == 0 && t.Kind == tokKeyword && clauseKeywords[strings.ToUpper(t.Text)]:
+			act = strings.ToUpper(t.Text)
 			continue
 		}
-		i := strings.LastIndex(part, " ")
-		if i < 0 {
-			tables[strings.ToUpper(part)] = part
-			continue
+		if act != "" {
+			clauses[act] = append(clauses[act], t)
 		}
-		tables[strings.ToUpper(part[i+1:])] = part[:i]
 	}
-	return tables
+	from, ok := clauses["FROM"]
+	if !ok {
+		glog.V(1).Infof("cannot find FROM in %v", toks)
+		return next
+	}
+	q := &query{Next: next, Outer: sc.outer, With: with}
+	var conds [][]token
+	q.Tables, conds, q.Equations = parseFrom(from, sc)
+	q.Columns = parseSelectList(clauses["SELECT"])
+	if where, ok := clauses["WHERE"]; ok {
+		conds = append(conds, where)
+	}
+	for _, cond := range conds {
+		q.Equations = append(q.Equations, equations(withoutSubqueries(cond))...)
+	}
+	inner := sc.inner(q)
+	for _, toks := range append(conds, clauses["SELECT"], clauses["HAVING"]) {
+		q.Sub = append(q.Sub, subqueries(toks, inner)...)
+	}
+	return q
 }
 
-// findRe finds the pattern in code and ensures that it is not inside a () bracket pair.
-func findRe(code string, pattern *regexp.Regexp) int {
-	code = stripStrConsts(code)
-	lastCount, lastPos := 0, 0
-	for j := 0; j < len(code); j++ {
-		loc := pattern.FindStringIndex(code[j:])
-		if len(loc) == 0 {
-			return -1
+// parseWith parses the "name [(columns)] AS (subquery), ..." list after WITH,
+// and returns the factored subqueries, the scope with them, and the rest of the tokens.
+func parseWith(toks []token, sc scope) ([]*query, scope, []token) {
+	var with []*query
+	ctes := make(map[string]*query, len(sc.ctes)+2)
+	for k, v := range sc.ctes {
+		ctes[k] = v
+	}
+	sc.ctes = ctes
+	i := 0
+	for i < len(toks) && toks[i].isName() {
+		name := toks[i].name()
+		i++
+		var columns []string
+		if i < len(toks) && toks[i].is("(") {
+			j := matchingBracket(toks, i)
+			if j < 0 {
+				break
+			}
+			for _, t := range toks[i+1 : j] {
+				if t.isName() {
+					columns = append(columns, t.name())
+				}
+			}
+			i = j + 1
+		}
+		if !(i+1 < len(toks) && toks[i].is("AS") && toks[i+1].is("(")) {
+			break
 		}
-		j += loc[0]
-		cnt := lastCount + strings.Count(code[lastPos:j+1], "(") - strings.Count(code[lastPos:j], ")")
-		if cnt == 0 {
-			return j
+		j := matchingBracket(toks, i+1)
+		if j < 0 {
+			break
+		}
+		if sub := parseQueryScope(toks[i+2:j], sc); sub != nil {
+			for k := 0; k < len(columns) && k < len(sub.Columns); k++ {
+				sub.Columns[k].Name = columns[k]
+			}
+			ctes[name] = sub
+			with = append(with, sub)
+		}
+		if i = j + 1; i < len(toks) && toks[i].is(",") {
+			i++
 		}
-		lastCount, lastPos = cnt, loc[1]
 	}
-	return -1
+	return with, sc, toks[i:]
 }
 
-var rSelect = regexp.MustCompile(`(FOR\s+[^ ]+\s+IN\s*[(]|[^(]\s*)SELECT\s`)
+// parseSelectList parses the items of the select list.
+func parseSelectList(toks []token) []selectColumn {
+	if len(toks) > 0 && (toks[0].is("DISTINCT") || toks[0].is("ALL") || toks[0].is("UNIQUE")) {
+		toks = toks[1:]
+	}
+	var columns []selectColumn
+	for _, item := range splitTokens(toks, ",") {
+		n := len(item)
+		if n == 0 {
+			continue
+		}
+		var c selectColumn
+		switch {
+		case n == 1 && item[0].is("*"):
+			c = selectColumn{Name: "*", Ref: &colRef{}}
+		case n == 3 && item[0].isName() && item[1].is(".") && item[2].is("*"):
+			c = selectColumn{Name: "*", Ref: &colRef{Alias: item[0].name()}}
+		default:
+			// expr [AS] alias
+			if n > 1 && item[n-1].isName() && !item[n-2].is(".") {
+				c.Name = item[n-1].name()
+				if n--; item[n-1].is("AS") {
+					n--
+				}
+			}
+			switch {
+			case n == 1 && item[0].isName():
+				c.Ref = &colRef{Column: item[0].name()}
+			case n >= 3 && item[n-1].isName() && item[n-2].is("."):
+				if ref, j := parseColRef(item[:n], n-3); j == n {
+					c.Ref = &ref
+				}
+			}
+			if c.Name == "" && c.Ref != nil {
+				c.Name = c.Ref.Column
+			}
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
 
-// getSelects returns the select statements from the code
-func getSelects(code string) []string {
-	code = stripComments(code)
-	selects := make([]string, 0, 4)
-	i := 0
-	for {
-		loc := rSelect.FindStringIndex(code[i:])
-		if len(loc) == 0 {
+// subqueries returns the bracketed (SELECT ...) queries in the tokens,
+// which see sc (the outer tables for the correlated conditions).
+func subqueries(toks []token, sc scope) []*query {
+	var qs []*query
+	for i := 0; i+1 < len(toks); i++ {
+		if !(toks[i].is("(") && (toks[i+1].is("SELECT") || toks[i+1].is("WITH"))) {
+			continue
+		}
+		j := matchingBracket(toks, i)
+		if j < 0 {
 			break
 		}
-		start := i + loc[1] - 7
-		prefix := code[i+loc[0] : i+loc[0]+3]
-		i = start
-		glog.V(2).Infof("start=%d prefix=%q rest=%q", start, prefix, code[start:])
-		var end int
-		if prefix == "FOR" {
-			end = findEndBracket("("+code[start:]) - 1
-		} else {
-			end = findEndSemi(code[start:])
-		}
-		if end < 0 {
-			glog.V(1).Infof("cannot find end of %q in %q", prefix, code[start:])
+		if q := parseQueryScope(toks[i+1:j], sc); q != nil {
+			qs = append(qs, q)
+		}
+		i = j
+	}
+	return qs
+}
+
+// withoutSubqueries returns the tokens without the bracketed (SELECT ...) queries.
+func withoutSubqueries(toks []token) []token {
+	var rest []token
+	last := 0
+	for i := 0; i+1 < len(toks); i++ {
+		if !(toks[i].is("(") && (toks[i+1].is("SELECT") || toks[i+1].is("WITH"))) {
+			continue
+		}
+		j := matchingBracket(toks, i)
+		if j < 0 {
 			break
 		}
-		selects = append(selects, code[start:start+end])
-		i = start + end + 1
+		rest = append(rest, toks[last:i]...)
+		i, last = j, j+1
+	}
+	if last == 0 {
+		return toks
 	}
-	return selects
+	return append(rest, toks[last:]...)
 }
 
-// findEndSemi returns the closing semicolon
-func findEndSemi(code string) int {
-	return findNonStrConst(code, ";")
+// joinKeywords may precede a JOIN.
+var joinKeywords = map[string]bool{
+	"JOIN": true, "INNER": true, "CROSS": true, "NATURAL": true,
+	"LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true,
 }
 
-func findNonStrConst(code, ending string) int {
-	lastCount, lastPos := 0, 0
-	for j := 0; j < len(code); j += len(ending) {
-		k := strings.Index(code[j:], ending)
-		if k < 0 {
-			return -1
-		}
-		j += k
-		glog.V(2).Infof("j=%d rest=%q", j, code[j:])
-		cnt := lastCount + strings.Count(code[lastPos:j], "'")
-		if cnt%2 == 0 {
-			return j
+// parseFrom parses the FROM clause: returns the table references,
+// the ON conditions, and the equations of the USING (col1, col2) lists.
+func parseFrom(toks []token, sc scope) ([]tableRef, [][]token, [][2]colRef) {
+	var (
+		refs  []tableRef
+		conds [][]token
+		eqs   [][2]colRef
+	)
+	for _, part := range splitTokens(toks, ",") {
+		var prev string
+		for len(part) > 0 {
+			// table reference till ON, USING or the next JOIN
+			i := indexTopLevel(part, func(t token) bool {
+				return t.Kind == tokKeyword && (t.is("ON") || t.is("USING") || joinKeywords[strings.ToUpper(t.Text)])
+			})
+			if i < 0 {
+				i = len(part)
+			}
+			if ref, ok := parseTableRef(part[:i], sc); ok {
+				if i < len(part) && part[i].is("USING") && prev != "" &&
+					i+1 < len(part) && part[i+1].is("(") {
+					if j := matchingBracket(part, i+1); j > 0 {
+						for _, col := range part[i+2 : j] {
+							if col.isName() {
+								eqs = append(eqs, [2]colRef{
+									{Alias: prev, Column: col.name()},
+									{Alias: ref.Alias, Column: col.name()},
+								})
+							}
+						}
+					}
+				}
+				refs = append(refs, ref)
+				prev = ref.Alias
+			}
+			part = part[i:]
+			// skip the join condition
+			j := indexTopLevel(part, func(t token) bool {
+				return t.Kind == tokKeyword && joinKeywords[strings.ToUpper(t.Text)]
+			})
+			if j < 0 {
+				j = len(part)
+			}
+			if len(part) > 0 && part[0].is("ON") {
+				conds = append(conds, part[1:j])
+			}
+			for part = part[j:]; len(part) > 0 && joinKeywords[strings.ToUpper(part[0].Text)]; {
+				part = part[1:]
+			}
 		}
-		lastCount, lastPos = cnt, j
 	}
-	return -1
+	return refs, conds, eqs
 }
 
-// findEndBracket returns the closing bracket
-func findEndBracket(code string) int {
-	code = stripStrConsts(code)
-	glog.V(2).Infof("findEndBracket(%q)", code)
-	lastCount, lastPos := 0, 0
-	for j := 0; j < len(code); j++ {
-		k := strings.Index(code[j:], ")")
-		if k < 0 {
-			return -1
+// parseTableRef parses a "[owner.]table [[AS] alias]" or "(subquery) alias" reference.
+// The names of the factored subqueries of sc are resolved to them.
+func parseTableRef(toks []token, sc scope) (tableRef, bool) {
+	if len(toks) == 0 {
+		return tableRef{}, false
+	}
+	var ref tableRef
+	i := 0
+	if toks[0].is("(") {
+		if i = matchingBracket(toks, 0); i < 0 {
+			return ref, false
+		}
+		ref.Sub = parseQueryScope(toks[1:i], sc)
+		i++
+	} else {
+		var parts []string
+		for ; i < len(toks) && toks[i].isName(); i++ {
+			parts = append(parts, toks[i].Text)
+			ref.Owner, ref.Name = ref.Name, toks[i].name()
+			if i+1 >= len(toks) || !toks[i+1].is(".") {
+				i++
+				break
+			}
+			i++
 		}
-		j += k
-		glog.V(2).Infof("j=%d rest=%q", j, code[j:])
-		cnt := lastCount + strings.Count(code[lastPos:j+1], "(") - strings.Count(code[lastPos:j+1], ")")
-		if cnt == 0 {
-			return j
+		if ref.Name == "" {
+			return ref, false
+		}
+		ref.Raw = strings.Join(parts, ".")
+		ref.Alias = ref.Name
+		if sub := sc.ctes[ref.Name]; sub != nil && ref.Owner == "" {
+			ref.Name, ref.Raw, ref.Sub, ref.CTE = "", "", sub, true
 		}
-		lastCount, lastPos = cnt, j+1
 	}
-	return -1
+	if i < len(toks) && toks[i].is("AS") {
+		i++
+	}
+	if i < len(toks) && toks[i].isName() {
+		ref.Alias = toks[i].name()
+	}
+	return ref, ref.Alias != "" || ref.Sub != nil
 }
 
-var rStrConst = regexp.MustCompile("'[^']*'")
+// equations returns the alias.column = alias.column pairs of the condition.
+func equations(toks []token) [][2]colRef {
+	var eqs [][2]colRef
+	for i := 0; i < len(toks); i++ {
+		left, j := parseColRef(toks, i)
+		if j < 0 {
+			continue
+		}
+		if j >= len(toks) || !(toks[j].is("=") || toks[j].is("LIKE")) {
+			i = j - 1
+			continue
+		}
+		right, k := parseColRef(toks, j+1)
+		if k < 0 {
+			glog.V(2).Infof("no column after %v", toks[i:j+1])
+			continue
+		}
+		eqs = append(eqs, [2]colRef{left, right})
+		i = k - 1
+	}
+	return eqs
+}
 
-// stripStrConsts replaces all 'xxsd' strings with '____' (equal length)
-func stripStrConsts(code string) string {
-	return rStrConst.ReplaceAllStringFunc(code, func(needle string) string {
-		return "'" + strings.Repeat("_", len(needle)-2) + "'"
-	})
+// parseColRef parses an [[owner.]alias.]column reference at toks[i],
+// with an optional (+) outer join marker. A :bind variable is not a column,
+// neither is a name followed by "(", such as a function call.
+// Returns the position after the reference, or -1.
+func parseColRef(toks []token, i int) (colRef, int) {
+	if i > 0 && (toks[i-1].is(".") || toks[i-1].is(":")) {
+		return colRef{}, -1
+	}
+	j := i
+	for j < len(toks) && toks[j].isName() && j+1 < len(toks) && toks[j+1].is(".") {
+		j += 2
+	}
+	if j >= len(toks) || !toks[j].isName() {
+		return colRef{}, -1
+	}
+	ref := colRef{Column: toks[j].name()}
+	if j > i {
+		ref.Alias = toks[j-2].name()
+	}
+	j++
+	if j < len(toks) && toks[j].is("(") {
+		if !(j+2 < len(toks) && toks[j+1].is("+") && toks[j+2].is(")")) {
+			return colRef{}, -1
+		}
+		j += 3
+	}
+	return ref, j
 }
 
-var rLineComment = regexp.MustCompile("--[^\n]*(?:\n|$)")
-var rComment = regexp.MustCompile("(?s)/[*].*?[*]/")
+// splitTokens splits the tokens at the sep operator, but not inside brackets.
+func splitTokens(toks []token, sep string) [][]token {
+	var parts [][]token
+	last := 0
+	for {
+		i := indexTopLevel(toks[last:], func(t token) bool { return t.is(sep) })
+		if i < 0 {
+			break
+		}
+		parts = append(parts, toks[last:last+i])
+		last += i + 1
+	}
+	return append(parts, toks[last:])
+}
 
-// stripComments strips the comments from the PL/SQL code
-func stripComments(code string) string {
-	ocode := code
-	code = rLineComment.ReplaceAllStringFunc(code, func(needle string) string {
-		n := len(needle)
-		if needle[n-1] == '\n' {
-			return strings.Repeat(" ", n-1) + "\n"
+// indexTopLevel returns the index of the first token for which f returns true,
+// not inside a () bracket pair, or -1.
+func indexTopLevel(toks []token, f func(token) bool) int {
+	depth := 0
+	for i, t := range toks {
+		if depth == 0 && f(t) {
+			return i
 		}
-		return strings.Repeat(" ", n)
-	})
-	code = rComment.ReplaceAllStringFunc(code, func(needle string) string {
-		return strings.Map(
-			func(r rune) rune {
-				if r == '\n' {
-					return '\n'
-				}
-				return ' '
-			}, needle)
-	})
-	glog.V(2).Infof("%q => %q", ocode, code)
-	return code
+		switch {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		}
+	}
+	return -1
 }
@@ -18,20 +18,65 @@ package main
 
 import (
 	"reflect"
-	"regexp"
+	"strings"
 	"testing"
 )
 
-var rSpaces = regexp.MustCompile("[ \t\n]+")
-
 func TestLinks(t *testing.T) {
 	for i, c := range []struct {
 		Code  string
-		Links [][2]string
+		Links []link
 	}{
 		{"aaa", nil},
 		{"SELECT x FROM table A WHERE A.f= 1", nil},
-		{"SELECT x FROM Btab B, Atab A WHERE A.f = B.c", [][2]string{{"A.f", "B.c"}}},
+		{"SELECT x FROM Btab B, Atab A WHERE A.f = B.c",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM Atab A JOIN Btab B ON A.f = B.c",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM Atab A LEFT OUTER JOIN Btab B ON (A.f = B.c) WHERE B.d = 1",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM Atab A INNER JOIN Btab B ON A.f = B.c JOIN Ctab C ON C.g = B.h ORDER BY 1",
+			[]link{
+				{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}},
+				{A: linkField{Table: "BTAB", Fields: []string{"H"}}, B: linkField{Table: "CTAB", Fields: []string{"G"}}},
+			}},
+		{"SELECT x FROM Atab A FULL JOIN Btab B USING (f, g)",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F", "G"}}, B: linkField{Table: "BTAB", Fields: []string{"F", "G"}}}}},
+		{"SELECT x FROM atab a, btab b WHERE b.k2 = a.k2 AND a.k1 = b.k1 AND a.k1 = b.k1",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"K1", "K2"}}, B: linkField{Table: "BTAB", Fields: []string{"K1", "K2"}}}}},
+		{"SELECT x FROM atab a1, atab a2, btab b WHERE a1.f = b.c AND a2.g = b.d",
+			[]link{
+				{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}},
+				{A: linkField{Table: "ATAB", Fields: []string{"G"}}, B: linkField{Table: "BTAB", Fields: []string{"D"}}},
+			}},
+		{`SELECT x FROM "My Table" m, btab b WHERE m."Id" = b.m_id(+) AND b.x = '--'`,
+			[]link{{A: linkField{Table: "BTAB", Fields: []string{"M_ID"}}, B: linkField{Table: "My Table", Fields: []string{"Id"}}}}},
+		{"SELECT x FROM atab a, btab b WHERE a.s = q'[ WHERE ]' AND a.f = b.c",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM atab a WHERE a.f = 1 UNION SELECT y FROM atab a, btab b WHERE a.f = b.c",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM atab a WHERE EXISTS (SELECT 1 FROM btab WHERE a.f = NVL(c, 0))",
+			[]link{}},
+		{"SELECT x FROM own.btab b, atab a WHERE a.f = b.c",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Owner: "OWN", Table: "BTAB", Fields: []string{"C"}}}}},
+		{"SELECT x FROM atab a, (SELECT b.c AS k, b.d FROM btab b) v WHERE v.k = a.f AND a.g = v.d",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F", "G"}}, B: linkField{Table: "BTAB", Fields: []string{"C", "D"}}}}},
+		{"SELECT x FROM atab a JOIN (SELECT * FROM (SELECT c FROM btab)) v ON v.c = a.f",
+			[]link{{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}}}},
+		{"WITH w (k) AS (SELECT b.c FROM btab b, ctab c WHERE c.g = b.h), v AS (SELECT k FROM w) SELECT x FROM atab a, v WHERE v.k = a.f",
+			[]link{
+				{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}},
+				{A: linkField{Table: "BTAB", Fields: []string{"H"}}, B: linkField{Table: "CTAB", Fields: []string{"G"}}},
+			}},
+		{"SELECT x FROM atab a WHERE EXISTS (SELECT 1 FROM btab b WHERE b.c = a.f AND b.d IN (SELECT c.g FROM ctab c WHERE c.h = b.e))",
+			[]link{
+				{A: linkField{Table: "ATAB", Fields: []string{"F"}}, B: linkField{Table: "BTAB", Fields: []string{"C"}}},
+				{A: linkField{Table: "BTAB", Fields: []string{"E"}}, B: linkField{Table: "CTAB", Fields: []string{"H"}}},
+			}},
+		{"SELECT (SELECT MAX(b.c) FROM btab a WHERE a.f = b.c) FROM btab b",
+			[]link{}},
+		{"SELECT x FROM own.btab b JOIN other.atab a ON a.f = b.c",
+			[]link{{A: linkField{Owner: "OTHER", Table: "ATAB", Fields: []string{"F"}}, B: linkField{Owner: "OWN", Table: "BTAB", Fields: []string{"C"}}}}},
 	} {
 		got := selectGetLinks(c.Code)
 		if len(got) != len(c.Links) {
@@ -39,14 +84,14 @@ func TestLinks(t *testing.T) {
 			continue
 		}
 		for j, v := range got {
-			if !(v[0] == c.Links[j][0] && v[1] == c.Links[j][1]) {
-				t.Errorf("%d. %d mismatch: got %s, awaited %s (%q).", i, j, got, c.Links, c.Code)
+			if !reflect.DeepEqual(v, c.Links[j]) {
+				t.Errorf("%d. %d mismatch: got %v, awaited %v (%q).", i, j, got, c.Links, c.Code)
 			}
 		}
 	}
 }
 
-func TestFromTables(t *testing.T) {
+func TestParseFrom(t *testing.T) {
 	for i, c := range []struct {
 		From   string
 		Tables map[string]string
@@ -54,8 +99,13 @@ func TestFromTables(t *testing.T) {
 		{"aaa", map[string]string{"AAA": "aaa"}},
 		{"table A", map[string]string{"A": "table"}},
 		{"Btab B, Atab A, Ctab", map[string]string{"A": "Atab", "B": "Btab", "CTAB": "Ctab"}},
+		{"own.Btab B, other.Atab", map[string]string{"B": "own.Btab", "ATAB": "other.Atab"}},
 	} {
-		got := fromTables(c.From)
+		refs, _, _ := parseFrom(significant(lex(c.From)), scope{})
+		got := make(map[string]string, len(refs))
+		for _, ref := range refs {
+			got[ref.Alias] = ref.Raw
+		}
 		if len(got) != len(c.Tables) {
 			t.Errorf("%d. count mismatch: got %d, awaited %d (%q).", i, len(got), len(c.Tables), c.From)
 			continue
@@ -66,105 +116,51 @@ func TestFromTables(t *testing.T) {
 	}
 }
 
-func TestSelects(t *testing.T) {
+func TestCursorLinks(t *testing.T) {
 	for i, c := range []struct {
-		Code    string
-		Selects []string
+		Code, Links string
 	}{
-		{"aaa", nil},
-		{"aaa--SELECT;", nil},
-		{"aa/*SELECT;*/bb", nil},
-		{`aa/*SELECT
-		;*/sds`, nil},
-		{"dasd SELECT f; sdasd", []string{"SELECT f"}},
-		{`dassd SELECT --
-		from a /*
-		sdasd;*/
-		WHERE a=';'
-		--;
-		;aaa`, []string{`SELECT
-		from a
-
-		WHERE a=';'
-
-		`},
-		},
-		{"FOR sor IN (SELECT A FROM (SELECT B))", []string{"SELECT A FROM (SELECT B)"}},
+		{`CURSOR c IS SELECT a.x FROM atab a, btab b WHERE b.id = a.b_id;`, "ATAB.B_ID=BTAB.ID"},
+		{`CURSOR c(p_id NUMBER, p_x IN VARCHAR2 DEFAULT 'a') IS
+		    SELECT a.x FROM atab a JOIN btab b ON b.id = a.b_id WHERE a.id = p_id;`, "ATAB.B_ID=BTAB.ID"},
+		{`CURSOR c RETURN atab%ROWTYPE IS SELECT a.* FROM atab a, btab b WHERE b.id = a.b_id FOR UPDATE OF a.x;`,
+			"ATAB.B_ID=BTAB.ID"},
+		{`CURSOR c IS WITH w AS (SELECT id FROM btab) SELECT a.x FROM atab a, w WHERE w.id = a.b_id;`,
+			"ATAB.B_ID=BTAB.ID"},
+		{`FOR rec IN (SELECT a.x FROM atab a, btab b WHERE b.id = a.b_id) LOOP NULL; END LOOP;`, "ATAB.B_ID=BTAB.ID"},
+		{`FOR rec IN c(1) LOOP NULL; END LOOP; FOR rec IN (SELECT x FROM atab) LOOP NULL; END LOOP;`, ""},
+		{`OPEN c FOR SELECT a.x FROM atab a, btab b WHERE b.id = a.b_id;`, "ATAB.B_ID=BTAB.ID"},
+		{`OPEN c(1); FETCH c BULK COLLECT INTO v_tab LIMIT 100; CLOSE c;`, ""},
+		{`SELECT a.x, b.y BULK COLLECT INTO v_xs, v_ys FROM atab a, btab b WHERE b.id = a.b_id;`, "ATAB.B_ID=BTAB.ID"},
+		{`SELECT a.x INTO v_x FROM atab a, btab b WHERE b.id = a.b_id;`, "ATAB.B_ID=BTAB.ID"},
+		{`FORALL i IN 1..v_tab.COUNT UPDATE atab a SET x = 1 WHERE a.b_id = (SELECT b.id FROM btab b WHERE b.id = a.b_id);`,
+			"ATAB.B_ID=BTAB.ID"},
+		{`DELETE FROM atab a WHERE EXISTS (SELECT 1 FROM btab b WHERE b.id = a.b_id) RETURNING a.x BULK COLLECT INTO v_xs;`,
+			"ATAB.B_ID=BTAB.ID"},
 	} {
-		got := getSelects(c.Code)
-		if len(got) != len(c.Selects) {
-			t.Errorf("%d. count mistmatch: got %d, awaited %d (%q).", i, len(got), len(c.Selects), c.Code)
-			continue
-		}
-		if len(got) == 0 {
-			continue
-		}
-		for j, txt := range got {
-			if txt != c.Selects[j] && stripSpaces(txt) != stripSpaces(c.Selects[j]) {
-				t.Errorf("%d. %d: got %q, awaited %q.", i, j, stripSpaces(txt), stripSpaces(c.Selects[j]))
+		var links []string
+		for _, code := range getStatements(c.Code) {
+			for _, lnk := range parseStatement(significant(lex(code))).links() {
+				links = append(links, lnk.A.Table+"."+strings.Join(lnk.A.Fields, ",")+"="+
+					lnk.B.Table+"."+strings.Join(lnk.B.Fields, ","))
 			}
 		}
-	}
-}
-func TestStripComment(t *testing.T) {
-	for i, c := range [][2]string{
-		{"aaa", "aaa"},
-		{"aaa--SELECT;", "aaa         "},
-		{"aa/*SELECT;*/bb", "aa           bb"},
-		{`aa/*SELECT
-		;*/sds`, `aa      sds`},
-		{`dassd SELECT --
-		from a /*
-		sdasd;*/
-		WHERE a=';'
-		--;
-		;aaa`, `dassd SELECT
-		from a
-
-		WHERE a=';'
-
-		;aaa`},
-	} {
-		got := stripComments(c[0])
-		if got != c[1] && stripSpaces(got) != stripSpaces(c[1]) {
-			t.Errorf("%d. got %q, awaited %q.", i, stripSpaces(got), stripSpaces(c[1]))
+		if got := strings.Join(links, " "); got != c.Links {
+			t.Errorf("%d. got %q, awaited %q (%q).", i, got, c.Links, c.Code)
 		}
 	}
 }
 
-func TestFindEndSemi(t *testing.T) {
-	for i, c := range []struct {
-		Text string
-		Pos  int
-	}{
-		{"abraka", -1},
-		{"aaa;sdd", 3},
-		{"aa';';", 5},
-	} {
-		got := findEndSemi(c.Text)
-		if got != c.Pos {
-			t.Errorf("%d. got %d, awaited %d (%q)", i, got, c.Pos, c.Text)
-		}
+func TestBulkCollectColumns(t *testing.T) {
+	q := parseQuery(significant(lex("SELECT a.x, b.y yy BULK COLLECT INTO v_xs, v_ys FROM atab a, btab b")))
+	if q == nil {
+		t.Fatal("no query")
 	}
-}
-
-func TestFindEndBracket(t *testing.T) {
-	for i, c := range []struct {
-		Text string
-		Pos  int
-	}{
-		{"(abraka", -1},
-		{"(aaa)sdd", 4},
-		{"(aa')')", 6},
-		{"(aa()')')", 8},
-	} {
-		got := findEndBracket(c.Text)
-		if got != c.Pos {
-			t.Errorf("%d. got %d, awaited %d (%q)", i, got, c.Pos, c.Text)
-		}
+	var got []string
+	for _, c := range q.Columns {
+		got = append(got, c.Name)
+	}
+	if awaited := "X YY"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", got, awaited)
 	}
-}
-
-func stripSpaces(text string) string {
-	return rSpaces.ReplaceAllString(text, " ")
 }
@@ -0,0 +1,148 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	renderers["plantuml"] = func(opts renderOptions) renderer { return plantumlRenderer{opts} }
+}
+
+// plantumlRenderer renders the graph as PlantUML entities,
+// in a package per schema if there are more than one.
+type plantumlRenderer struct {
+	renderOptions
+}
+
+func (r plantumlRenderer) Render(w io.Writer, g *graph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("@startuml\nhide circle\nskinparam linetype ortho\n\n")
+	for _, owner := range g.Owners {
+		indent := ""
+		if len(g.Owners) > 1 {
+			fmt.Fprintf(bw, "package %q {\n", owner)
+			indent = "  "
+		}
+		tables, orphans := g.ownerTables(owner)
+		for _, t := range tables {
+			r.writeEntity(bw, indent, t)
+		}
+		if len(orphans) > 0 {
+			fmt.Fprintf(bw, "%spackage %q {\n", indent, "orphans")
+			for _, t := range orphans {
+				r.writeEntity(bw, indent+"  ", t)
+			}
+			fmt.Fprintf(bw, "%s}\n", indent)
+		}
+		if len(g.Owners) > 1 {
+			bw.WriteString("}\n")
+		}
+	}
+	bw.WriteByte('\n')
+	for _, e := range g.Edges {
+		rel := r.erRelation(e)
+		fmt.Fprintf(bw, "%s %s %s : %s\n",
+			plantumlID(e.A.Owner, e.A.Table), rel, plantumlID(e.B.Owner, e.B.Table),
+			r.edgeLabel(e))
+	}
+	bw.WriteString("@enduml\n")
+	return bw.Flush()
+}
+
+// writeEntity writes the table as an entity.
+// With CommentLabel, the table comment is under the name, and the column comments
+// follow the types; with CommentTooltip, the comments are in a [[{tooltip}]] link.
+func (r plantumlRenderer) writeEntity(bw *bufio.Writer, indent string, t graphTable) {
+	name := t.Name
+	if r.CommentLabel {
+		for _, line := range r.commentLines(t.Comment) {
+			name += "\n" + line
+		}
+	}
+	stereotype := ""
+	if t.View {
+		stereotype = " <<view>>"
+	}
+	tooltip := ""
+	if text := t.tooltip(); r.CommentTooltip && text != "" {
+		tooltip = " [[{" + plantumlTooltipReplacer.Replace(text) + "}]]"
+	}
+	fmt.Fprintf(bw, "%sentity %q as %s%s%s {\n", indent, name, plantumlID(t.Owner, t.Name), stereotype, tooltip)
+	for _, f := range t.Used {
+		fmt.Fprintf(bw, "%s  %s : %s", indent, f.Name, f.Type)
+		if lines := r.commentLines(f.Comment); r.CommentLabel && len(lines) != 0 {
+			bw.WriteString(" -- " + strings.Join(lines, " "))
+		}
+		bw.WriteByte('\n')
+	}
+	fmt.Fprintf(bw, "%s}\n", indent)
+}
+
+// plantumlTooltipReplacer escapes the new lines, and replaces the brackets
+// closing the link of the tooltip.
+var plantumlTooltipReplacer = strings.NewReplacer("\n", `\n`, "}", ")", "]", ")")
+
+func plantumlID(owner, name string) string {
+	if owner != "" {
+		name = owner + "__" + name
+	}
+	return simpleID(name)
+}
+
+// RenderDeps renders the dependency graph as components (tables and views as databases),
+// with the cycles filled and red.
+func (r plantumlRenderer) RenderDeps(w io.Writer, g *depGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("@startuml\n\n")
+	for _, n := range g.Nodes {
+		element, stereotype := "component", ""
+		switch n.Kind {
+		case "TABLE":
+			element = "database"
+		case "VIEW":
+			element, stereotype = "database", " <<view>>"
+		case "":
+		default:
+			stereotype = " <<" + n.Kind + ">>"
+		}
+		color := ""
+		if n.Cycle != 0 {
+			color = " " + cycleColor
+		}
+		fmt.Fprintf(bw, "%s %q as %s%s%s\n", element, qualifiedName(n.Owner, n.Name), plantumlID(n.Owner, n.Name), stereotype, color)
+	}
+	bw.WriteByte('\n')
+	for _, e := range g.Edges {
+		from, to := g.Nodes[e.From], g.Nodes[e.To]
+		arrow := "-->"
+		if e.Cycle {
+			arrow = "-[#red]->"
+		}
+		fmt.Fprintf(bw, "%s %s %s", plantumlID(from.Owner, from.Name), arrow, plantumlID(to.Owner, to.Name))
+		if e.Label != "" {
+			bw.WriteString(" : " + e.Label)
+		}
+		bw.WriteByte('\n')
+	}
+	bw.WriteString("@enduml\n")
+	return bw.Flush()
+}
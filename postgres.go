@@ -0,0 +1,256 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"io"
+
+	"github.com/golang/glog"
+	"github.com/lib/pq"
+	"gopkg.in/errgo.v1"
+)
+
+func init() {
+	providers["postgres"] = func(db *sql.DB, opts providerOptions) schemaProvider {
+		return postgresProvider{db: db, schemas: opts.Schemas}
+	}
+}
+
+// postgresProvider reads the tables from information_schema,
+// and the functions and procedures from pg_proc.
+//
+// Without schemas, only the current schema (search_path) is read,
+// and the owners are left empty in the model.
+type postgresProvider struct {
+	db      *sql.DB
+	schemas []string
+}
+
+// schemaCond returns the condition restricting the schema column to the schemas,
+// and the bind parameters for it.
+func (p postgresProvider) schemaCond(column string) (string, []interface{}) {
+	if len(p.schemas) == 0 {
+		return column + " = current_schema()", nil
+	}
+	return column + " = ANY($1)", []interface{}{pq.Array(p.schemas)}
+}
+
+// owner returns the owner (schema) as stored in the model.
+func (p postgresProvider) owner(schema string) string {
+	if len(p.schemas) == 0 {
+		return ""
+	}
+	return foldName(schema)
+}
+
+func (p postgresProvider) TableNames() (map[string]string, error) {
+	cond, params := p.schemaCond("A.table_schema")
+	qry := `SELECT A.table_schema, A.table_name,
+	           COALESCE(obj_description((quote_ident(A.table_schema)||'.'||quote_ident(A.table_name))::regclass, 'pg_class'), '')
+	      FROM information_schema.tables A
+	      WHERE ` + cond + ` AND A.table_type = 'BASE TABLE'`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	tables := make(map[string]string, 128)
+	for rows.Next() {
+		var schema, name, comment string
+		if err = rows.Scan(&schema, &name, &comment); err != nil {
+			glog.Warningf("error scanning table name: %v", err)
+			continue
+		}
+		name = qualifiedName(p.owner(schema), foldName(name))
+		glog.V(1).Infof("table %s (%q)", name, comment)
+		tables[name] = comment
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return tables, errgo.Notef(err, "rows")
+	}
+	return tables, nil
+}
+
+func (p postgresProvider) Tables() ([]table, error) {
+	tableNames, err := p.TableNames()
+	if err != nil {
+		return nil, errgo.Notef(err, "table names")
+	}
+	views, err := p.views()
+	if err != nil {
+		return nil, errgo.Notef(err, "views")
+	}
+
+	cond, params := p.schemaCond("A.table_schema")
+	qry := `SELECT A.table_schema, A.table_name, A.column_name, A.data_type,
+	           COALESCE(col_description((quote_ident(A.table_schema)||'.'||quote_ident(A.table_name))::regclass, A.ordinal_position), '')
+	      FROM information_schema.columns A, information_schema.tables B
+	      WHERE B.table_schema = A.table_schema AND B.table_name = A.table_name AND
+	            B.table_type IN ('BASE TABLE', 'VIEW') AND ` + cond + `
+	      ORDER BY A.table_schema, A.table_name, A.ordinal_position`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	tables := make([]table, 0, len(tableNames))
+	var schema, name, prev string
+	for rows.Next() {
+		var f field
+		if err = rows.Scan(&schema, &name, &f.Name, &f.Type, &f.Comment); err != nil {
+			glog.Warningf("error scanning field: %v", err)
+			continue
+		}
+		owner := p.owner(schema)
+		name, f.Name = foldName(name), foldName(f.Name)
+		if act := qualifiedName(owner, name); prev != act {
+			prev = act
+			t, ok := views[act]
+			if !ok {
+				t = table{Owner: owner, Name: name, Comment: tableNames[act]}
+			}
+			t.Fields = make([]field, 0, 8)
+			tables = append(tables, t)
+		}
+		t := &tables[len(tables)-1]
+		t.Fields = append(t.Fields, f)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return tables, errgo.Mask(err)
+	}
+
+	constraints, err := p.constraints()
+	if err != nil {
+		return tables, errgo.Notef(err, "constraints")
+	}
+	for i, t := range tables {
+		tables[i].Constraints = constraints[qualifiedName(t.Owner, t.Name)]
+	}
+	return tables, nil
+}
+
+// views returns the views, with their comments and definitions, per qualified name.
+func (p postgresProvider) views() (map[string]table, error) {
+	cond, params := p.schemaCond("A.table_schema")
+	qry := `SELECT A.table_schema, A.table_name,
+	           COALESCE(obj_description((quote_ident(A.table_schema)||'.'||quote_ident(A.table_name))::regclass, 'pg_class'), ''),
+	           COALESCE(A.view_definition, '')
+	      FROM information_schema.views A
+	      WHERE ` + cond
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	views := make(map[string]table, 32)
+	for rows.Next() {
+		var schema string
+		t := table{View: true}
+		if err = rows.Scan(&schema, &t.Name, &t.Comment, &t.Text); err != nil {
+			glog.Warningf("error scanning view: %v", err)
+			continue
+		}
+		t.Owner, t.Name = p.owner(schema), foldName(t.Name)
+		glog.V(1).Infof("view %s (%q)", t.Name, t.Comment)
+		views[qualifiedName(t.Owner, t.Name)] = t
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return views, errgo.Notef(err, "rows")
+	}
+	return views, nil
+}
+
+// constraints returns the primary key, unique key and foreign key
+// constraints, per qualified table name.
+func (p postgresProvider) constraints() (map[string][]constraint, error) {
+	cond, params := p.schemaCond("A.table_schema")
+	qry := `SELECT A.table_schema, A.table_name, A.constraint_name,
+	           CASE A.constraint_type WHEN 'PRIMARY KEY' THEN 'P' WHEN 'UNIQUE' THEN 'U' ELSE 'R' END,
+	           B.column_name, COALESCE(D.table_schema, ''), COALESCE(D.table_name, ''),
+	           COALESCE(D.column_name, '')
+	      FROM information_schema.table_constraints A
+	      JOIN information_schema.key_column_usage B
+	        ON B.constraint_schema = A.constraint_schema AND B.constraint_name = A.constraint_name
+	      LEFT JOIN information_schema.referential_constraints C
+	        ON C.constraint_schema = A.constraint_schema AND C.constraint_name = A.constraint_name
+	      LEFT JOIN information_schema.key_column_usage D
+	        ON D.constraint_schema = C.unique_constraint_schema AND
+	           D.constraint_name = C.unique_constraint_name AND
+	           D.ordinal_position = B.position_in_unique_constraint
+	      WHERE ` + cond + ` AND
+	            A.constraint_type IN ('PRIMARY KEY', 'UNIQUE', 'FOREIGN KEY')
+	      ORDER BY A.table_schema, A.table_name, A.constraint_name, B.ordinal_position`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	constraints := make(map[string][]constraint, 128)
+	var act, prev string
+	for rows.Next() {
+		var schema, tbl, name, typ, fld, refSchema, refTbl, refFld string
+		if err = rows.Scan(&schema, &tbl, &name, &typ, &fld, &refSchema, &refTbl, &refFld); err != nil {
+			glog.Warningf("error scanning constraint: %v", err)
+			continue
+		}
+		tbl = qualifiedName(p.owner(schema), foldName(tbl))
+		if act = tbl + "." + name; act != prev {
+			prev = act
+			constraints[tbl] = append(constraints[tbl],
+				constraint{Name: name, Type: typ, Fields: make([]string, 0, 2)})
+		}
+		cs := constraints[tbl]
+		k := &cs[len(cs)-1]
+		k.Fields = append(k.Fields, foldName(fld))
+		if k.Type == "R" {
+			k.RefOwner, k.RefTable = p.owner(refSchema), foldName(refTbl)
+			k.RefFields = append(k.RefFields, foldName(refFld))
+		}
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return constraints, errgo.Mask(err)
+	}
+	return constraints, nil
+}
+
+func (p postgresProvider) Sources() ([]source, error) {
+	cond, params := p.schemaCond("B.nspname")
+	qry := `SELECT B.nspname, A.proname, CASE A.prokind WHEN 'p' THEN 'PROCEDURE' ELSE 'FUNCTION' END, A.prosrc
+	      FROM pg_proc A, pg_namespace B
+	      WHERE B.oid = A.pronamespace AND ` + cond + `
+	      ORDER BY B.nspname, A.proname`
+	rows, err := p.db.Query(qry, params...)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	sources := make([]source, 0, 64)
+	for rows.Next() {
+		var s source
+		if err = rows.Scan(&s.Owner, &s.Name, &s.Type, &s.Code); err != nil {
+			glog.Warningf("error scanning source: %v", err)
+			continue
+		}
+		s.Owner, s.Name = p.owner(s.Owner), foldName(s.Name)
+		sources = append(sources, s)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return sources, errgo.Mask(err)
+	}
+	return sources, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// schemaProvider reads the tables (with their fields and constraints)
+// and the program sources from a database.
+type schemaProvider interface {
+	// TableNames returns the (qualified) table names with their comments.
+	TableNames() (map[string]string, error)
+	// Tables returns the tables with their fields and constraints.
+	Tables() ([]table, error)
+	// Sources returns the stored program units.
+	Sources() ([]source, error)
+}
+
+// dependencyProvider is implemented by the providers which can read
+// the dependencies between the program units from the data dictionary.
+type dependencyProvider interface {
+	// Dependencies returns the references of the program units to the other program units.
+	Dependencies() ([]dependency, error)
+}
+
+// providerOptions are the options of the schemaProviders.
+type providerOptions struct {
+	// Schemas to read. Without schemas, the user's own schema is read,
+	// and the owners are left empty.
+	Schemas []string
+	// DBA is true if the DBA_* views should be read instead of the ALL_* views.
+	DBA bool
+}
+
+// providers maps the database/sql driver names (the -driver flag)
+// to the schemaProvider constructors.
+var providers = make(map[string]func(*sql.DB, providerOptions) schemaProvider, 4)
+
+// providerNames returns the registered driver names, sorted.
+func providerNames() []string {
+	names := make([]string, 0, len(providers))
+	for k := range providers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// foldName returns the name as the parser sees it: unquoted identifiers are
+// upper cased by the parser (as Oracle does), so the names stored in lower case
+// (as PostgreSQL stores the unquoted identifiers) are upper cased, too.
+func foldName(name string) string {
+	if name == strings.ToLower(name) {
+		return strings.ToUpper(name)
+	}
+	return name
+}
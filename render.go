@@ -0,0 +1,261 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// renderer writes the graph in a diagram language.
+type renderer interface {
+	Render(w io.Writer, g *graph) error
+	// RenderDeps writes the dependency graph, with the cycles highlighted.
+	RenderDeps(w io.Writer, g *depGraph) error
+}
+
+// cycleColor is the fill color of the nodes in a cycle of a dependency graph.
+const cycleColor = "#ffcccc"
+
+// renderOptions are the options of the renderers.
+type renderOptions struct {
+	// Style of the tables: record or html (DOT only).
+	Style string
+	// Colors of the tables, by name prefix.
+	Colors []prefixColor
+	// CommentLabel puts the table and column comments into the labels.
+	CommentLabel bool
+	// CommentTooltip puts the table and column comments into the tooltips.
+	CommentTooltip bool
+	// CommentWidth is the maximal width of the comments in the labels (0: unlimited).
+	CommentWidth int
+	// CommentWrap wraps the long comments instead of truncating them.
+	CommentWrap bool
+	// EdgeLabels puts the join count and the sources into the edge labels.
+	EdgeLabels bool
+	// Directed draws the edges from the foreign key (or non-unique) side
+	// to the referenced (unique) side, with crow's foot ends.
+	Directed bool
+}
+
+// commentLines returns the comment truncated or wrapped to CommentWidth.
+func (opts renderOptions) commentLines(comment string) []string {
+	comment = strings.Join(strings.Fields(comment), " ")
+	if comment == "" {
+		return nil
+	}
+	runes := []rune(comment)
+	if opts.CommentWidth <= 0 || len(runes) <= opts.CommentWidth {
+		return []string{comment}
+	}
+	if !opts.CommentWrap {
+		return []string{string(runes[:opts.CommentWidth-1]) + "…"}
+	}
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(comment) {
+		for len([]rune(word)) > opts.CommentWidth { // too long to fit in any line
+			if line != "" {
+				lines, line = append(lines, line), ""
+			}
+			w := []rune(word)
+			lines, word = append(lines, string(w[:opts.CommentWidth])), string(w[opts.CommentWidth:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= opts.CommentWidth:
+			line += " " + word
+		default:
+			lines, line = append(lines, line), word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// tooltip returns the tooltip text of the table: the table comment,
+// and the comments of the used fields.
+func (t graphTable) tooltip() string {
+	lines := make([]string, 0, 1+len(t.Used))
+	if t.Comment != "" {
+		lines = append(lines, t.Comment)
+	}
+	for _, f := range t.Used {
+		if f.Comment != "" {
+			lines = append(lines, f.Name+": "+f.Comment)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type prefixColor struct {
+	Prefix, Color string
+}
+
+// color returns the color of the first matching prefix, or the empty string.
+func (opts renderOptions) color(name string) string {
+	for _, pc := range opts.Colors {
+		if strings.HasPrefix(name, pc.Prefix) {
+			return pc.Color
+		}
+	}
+	return ""
+}
+
+// parseColors parses the "T_=lightblue,R_=#ffffcc" list.
+func parseColors(text string) ([]prefixColor, error) {
+	var colors []prefixColor
+	for _, part := range splitList(text) {
+		i := strings.IndexByte(part, '=')
+		if i <= 0 || i == len(part)-1 {
+			return colors, errgo.Newf("bad prefix=color pair %q", part)
+		}
+		colors = append(colors, prefixColor{Prefix: strings.TrimSpace(part[:i]), Color: strings.TrimSpace(part[i+1:])})
+	}
+	return colors, nil
+}
+
+// renderers maps the -format names to the renderer constructors.
+var renderers = make(map[string]func(renderOptions) renderer, 4)
+
+// rendererNames returns the registered format names, sorted.
+func rendererNames() []string {
+	names := make([]string, 0, len(renderers))
+	for k := range renderers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fieldPairs returns the "A_K1 = B_K1, A_K2 = B_K2" label of the edge.
+func (e edge) fieldPairs() string {
+	pairs := make([]string, len(e.A.Fields))
+	for i, f := range e.A.Fields {
+		pairs[i] = f + " = " + e.B.Fields[i]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// columnLabel returns the label of the edge for the diagrams which connect
+// the columns themselves: the field pairs of a composite key (as the edge
+// can connect only one column pair), and the sources if EdgeLabels is set.
+func (opts renderOptions) columnLabel(e edge) string {
+	var lines []string
+	if len(e.A.Fields) > 1 {
+		lines = append(lines, e.fieldPairs())
+	}
+	if sources := e.sourceList(); opts.EdgeLabels && sources != "" {
+		lines = append(lines, sources)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// edgeLabel returns the field pairs of the edge,
+// followed by the sources if EdgeLabels is set.
+func (opts renderOptions) edgeLabel(e edge) string {
+	label := e.fieldPairs()
+	if label == "" {
+		label = e.Kind.String()
+	}
+	if sources := e.sourceList(); opts.EdgeLabels && sources != "" {
+		label += " (" + sources + ")"
+	}
+	return label
+}
+
+// erRelation returns the crow's foot relation of the edge
+// in the Mermaid and PlantUML syntax, such as }o--|| for a 1:N foreign key.
+// Without Directed, the cardinality is not shown (every end is "zero or many").
+func (opts renderOptions) erRelation(e edge) string {
+	line := ".."
+	switch e.Kind {
+	case edgeForeignKey:
+		line = "--"
+	case edgeLineage:
+		return "}o..||" // many rows of the view from one row of the table
+	}
+	if !opts.Directed {
+		return "}o" + line + "o{"
+	}
+	aMany, bMany := e.Card.many()
+	a, b := "|o", "||"
+	if aMany {
+		a = "}o"
+	}
+	if bMany {
+		b = "o{"
+	}
+	return a + line + b
+}
+
+// width returns the line width of the edge, growing logarithmically
+// with the number of the joins.
+func (e edge) width() int {
+	width := 1
+	for n := e.Count; n > 1; n /= 2 {
+		width++
+	}
+	return width
+}
+
+// sourceList returns the "2× DB_A, DB_B" summary of the sources of the edge,
+// with "(1 dynamic)" appended if some joins are in dynamic SQL,
+// or the empty string if the edge has not been found in the code.
+func (e edge) sourceList() string {
+	if e.Count == 0 {
+		return ""
+	}
+	list := strconv.Itoa(e.Count) + "× " + strings.Join(e.Sources, ", ")
+	if e.Dynamic != 0 {
+		list += " (" + strconv.Itoa(e.Dynamic) + " dynamic)"
+	}
+	return list
+}
+
+// locationList returns the sourceList of the edge, followed by the
+// UNIT:line locations of the joins, one per line.
+func (e edge) locationList() string {
+	sources := e.sourceList()
+	if sources == "" || len(e.Locations) == 0 {
+		return sources
+	}
+	return sources + "\n" + strings.Join(e.Locations, "\n")
+}
+
+// dynamicOnly reports whether the edge has been found only in dynamic SQL.
+func (e edge) dynamicOnly() bool {
+	return e.Kind == edgeJoin && e.Count != 0 && e.Dynamic == e.Count
+}
+
+// simpleID returns the text with every character which is not
+// a letter, digit or underscore replaced by an underscore.
+func simpleID(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, text)
+}
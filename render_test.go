@@ -0,0 +1,291 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var flagUpdate = flag.Bool("update", false, "update the golden files")
+
+// checkGolden compares got with the testdata/name.golden file,
+// or writes it there with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	fn := filepath.Join("testdata", name+".golden")
+	if *flagUpdate {
+		if err := os.WriteFile(fn, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(fn)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: got\n%s\nawaited\n%s", name, got, want)
+	}
+}
+
+// testTables and testSources are the input of the golden file tests.
+var testTables = []table{
+	{Name: "T_CUSTOMER", Comment: "customers",
+		Fields:      []field{{"ID", "NUMBER(9)", "identifier"}, {"NAME", "VARCHAR2(100)", "full name"}},
+		Constraints: []constraint{{Name: "T_CUSTOMER_PK", Type: "P", Fields: []string{"ID"}}}},
+	{Name: "T_CONTRACT",
+		Fields: []field{{"ID", "NUMBER(9)", ""}, {"CUSTOMER_ID", "NUMBER(9)", "the owner"}, {"AMOUNT", "NUMBER(12,2)", ""}},
+		Constraints: []constraint{
+			{Name: "T_CONTRACT_PK", Type: "P", Fields: []string{"ID"}},
+			{Name: "T_CONTRACT_FK", Type: "R", Fields: []string{"CUSTOMER_ID"},
+				RefTable: "T_CUSTOMER", RefFields: []string{"ID"}},
+		}},
+	{Name: "R_ITEM",
+		Fields: []field{{"CONTRACT_ID", "NUMBER(9)", ""}, {"PRODUCT", "VARCHAR2(10)", ""}}},
+	{Name: "R_PRODUCT",
+		Fields: []field{{"CODE", "VARCHAR2(10)", ""}, {"NAME", "VARCHAR2(100)", ""}}},
+	{Name: "T_AUDIT", Comment: "not linked to anything",
+		Fields:      []field{{"ID", "NUMBER(9)", ""}, {"MESSAGE", "VARCHAR2(4000)", ""}},
+		Constraints: []constraint{{Name: "T_AUDIT_PK", Type: "P", Fields: []string{"ID"}}}},
+}
+
+var testSources = []source{
+	{Name: "DB_CONTRACT", Type: "PACKAGE BODY", Code: `
+PACKAGE BODY DB_CONTRACT IS
+PROCEDURE list IS
+BEGIN
+  FOR rec IN (SELECT A.amount, B.name FROM t_contract A, t_customer B WHERE B.id = A.customer_id) LOOP
+    NULL;
+  END LOOP;
+  SELECT COUNT(*) INTO v_cnt
+    FROM r_item I JOIN r_product P ON P.code = I.product
+    WHERE I.contract_id = p_id;
+  SELECT COUNT(*) INTO v_cnt FROM r_item I, t_contract C WHERE C.id = I.contract_id;
+END list;
+END DB_CONTRACT;
+`},
+	{Name: "DB_ITEM", Type: "PACKAGE BODY", Code: `
+PACKAGE BODY DB_ITEM IS
+FUNCTION amount(p_id IN NUMBER) RETURN NUMBER IS
+BEGIN
+  SELECT C.amount INTO v_amount FROM t_contract C, r_item I WHERE I.contract_id = C.id AND I.product = p_id;
+  RETURN v_amount;
+END amount;
+END DB_ITEM;
+`},
+}
+
+// compositeTables and compositeSources join on composite keys.
+var compositeTables = []table{
+	{Name: "T_LINE",
+		Fields:      []field{{"CONTRACT_ID", "NUMBER(9)", ""}, {"LINE_NO", "NUMBER(3)", ""}, {"AMOUNT", "NUMBER(12,2)", ""}},
+		Constraints: []constraint{{Name: "T_LINE_PK", Type: "P", Fields: []string{"CONTRACT_ID", "LINE_NO"}}}},
+	{Name: "T_NOTE",
+		Fields: []field{{"ID", "NUMBER(9)", ""}, {"CONTRACT_ID", "NUMBER(9)", ""}, {"LINE_NO", "NUMBER(3)", ""}},
+		Constraints: []constraint{
+			{Name: "T_NOTE_PK", Type: "P", Fields: []string{"ID"}},
+			{Name: "T_NOTE_FK", Type: "R", Fields: []string{"CONTRACT_ID", "LINE_NO"},
+				RefTable: "T_LINE", RefFields: []string{"CONTRACT_ID", "LINE_NO"}},
+		}},
+	{Name: "T_SHIPMENT",
+		Fields: []field{{"ID", "NUMBER(9)", ""}, {"CONTRACT", "NUMBER(9)", ""}, {"LINE", "NUMBER(3)", ""}}},
+}
+
+var compositeSources = []source{
+	{Name: "DB_LINE", Type: "PACKAGE BODY", Code: `
+PACKAGE BODY DB_LINE IS
+PROCEDURE ship IS
+BEGIN
+  SELECT COUNT(*) INTO v_cnt FROM t_line L, t_shipment S
+    WHERE S.line = L.line_no AND S.contract = L.contract_id;
+  SELECT COUNT(*) INTO v_cnt FROM t_line L JOIN t_note N USING (contract_id, line_no);
+END ship;
+END DB_LINE;
+`},
+}
+
+// viewTables adds a view over the contracts and customers to testTables.
+var viewTables = append(testTables[:len(testTables):len(testTables)], table{
+	Name: "V_CONTRACT", View: true, Comment: "contracts with their customers",
+	Fields: []field{{"ID", "NUMBER(9)", ""}, {"CUSTOMER_NAME", "VARCHAR2(100)", ""}, {"AMOUNT", "NUMBER(12,2)", ""}},
+	Text:   `SELECT A.id, B.name customer_name, A.amount FROM t_contract A, t_customer B WHERE B.id = A.customer_id`,
+})
+
+// filterViewTables adds a view which reads T_AUDIT only in its WHERE clause.
+var filterViewTables = append(testTables[:len(testTables):len(testTables)], table{
+	Name: "V_AUDITED", View: true,
+	Fields: []field{{"ID", "NUMBER(9)", ""}, {"AMOUNT", "NUMBER(12,2)", ""}},
+	Text:   `SELECT C.id, C.amount FROM t_contract C WHERE EXISTS (SELECT 1 FROM t_audit A WHERE A.message = 'checked')`,
+})
+
+func TestRenderGolden(t *testing.T) {
+	schemaTables := make([]table, len(testTables))
+	copy(schemaTables, testTables)
+	for i := range schemaTables {
+		schemaTables[i].Owner = "SALES"
+		if schemaTables[i].Name[0] == 'R' {
+			schemaTables[i].Owner = "REF"
+		}
+	}
+	schemaSources := []source{testSources[0]}
+	schemaSources[0].Owner = "SALES"
+
+	type testCase struct {
+		Name    string
+		Format  string
+		Options renderOptions
+		Analyze analyzeOptions
+		Tables  []table
+		Sources []source
+	}
+	var cases []testCase
+	for _, format := range rendererNames() {
+		cases = append(cases,
+			testCase{Name: "single", Format: format, Tables: testTables, Sources: testSources},
+			testCase{Name: "schemas", Format: format, Tables: schemaTables, Sources: schemaSources},
+			testCase{Name: "composite", Format: format,
+				Options: renderOptions{Style: "record", Directed: true},
+				Tables:  compositeTables, Sources: compositeSources},
+			testCase{Name: "single-directed", Format: format,
+				Options: renderOptions{Style: "record", Directed: true},
+				Tables:  testTables, Sources: testSources},
+			testCase{Name: "single-all-orphans", Format: format,
+				Analyze: analyzeOptions{Columns: "all", Unlinked: "cluster"},
+				Tables:  testTables, Sources: testSources},
+			testCase{Name: "views", Format: format, Tables: viewTables, Sources: testSources},
+			testCase{Name: "views-filter", Format: format, Tables: filterViewTables, Sources: testSources},
+		)
+	}
+	cases = append(cases,
+		testCase{Name: "single-html", Format: "dot",
+			Options: renderOptions{Style: "html", Colors: []prefixColor{{"T_", "lightblue"}}},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-colors", Format: "dot",
+			Options: renderOptions{Style: "record", Colors: []prefixColor{{"R_", "#ffffcc"}}},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-comments", Format: "dot",
+			Options: renderOptions{Style: "record", CommentLabel: true, CommentTooltip: true, CommentWidth: 6, CommentWrap: true},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-html-comments", Format: "dot",
+			Options: renderOptions{Style: "html", CommentLabel: true, CommentTooltip: true, CommentWidth: 6},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-comments", Format: "mermaid",
+			Options: renderOptions{CommentLabel: true},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-comments", Format: "plantuml",
+			Options: renderOptions{CommentLabel: true, CommentTooltip: true, CommentWidth: 20},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-comments", Format: "d2",
+			Options: renderOptions{CommentLabel: true, CommentTooltip: true, CommentWidth: 20},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-edge-labels", Format: "dot",
+			Options: renderOptions{Style: "record", EdgeLabels: true},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-edge-labels", Format: "d2",
+			Options: renderOptions{EdgeLabels: true},
+			Tables:  testTables, Sources: testSources},
+		testCase{Name: "single-keys", Format: "dot",
+			Analyze: analyzeOptions{Columns: "keys", Unlinked: "show"},
+			Tables:  testTables, Sources: testSources},
+	)
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := renderers[c.Format](c.Options).Render(&buf, analyze(c.Tables, c.Sources, c.Analyze)); err != nil {
+			t.Errorf("%s %s: %v", c.Name, c.Format, err)
+			continue
+		}
+		checkGolden(t, c.Name+"."+c.Format, buf.Bytes())
+	}
+}
+
+func TestRenderForeignKey(t *testing.T) {
+	tables := []table{
+		{Name: "T_A", Fields: []field{{"ID", "NUMBER", ""}, {"PARENT_ID", "NUMBER", ""}},
+			Constraints: []constraint{
+				{Name: "T_A_PK", Type: "P", Fields: []string{"ID"}},
+				{Name: "T_A_FK", Type: "R", Fields: []string{"PARENT_ID"}, RefTable: "T_A", RefFields: []string{"ID"}},
+			}},
+		{Name: "T_B", Fields: []field{{"ID", "NUMBER", ""}, {"A_ID", "NUMBER", ""}},
+			Constraints: []constraint{{Name: "T_B_FK", Type: "R", Fields: []string{"A_ID"},
+				RefTable: "T_A", RefFields: []string{"ID"}}}},
+	}
+	var buf bytes.Buffer
+	if err := renderers["dot"](renderOptions{Style: "record"}).Render(&buf, analyze(tables, nil, analyzeOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	for _, awaited := range []string{
+		"\ttable_T_B:A_ID -- table_T_A:ID [" + fkEdgeStyle + "];\n",
+		"\ttable_T_A:PARENT_ID -- table_T_A:ID [" + fkEdgeStyle + "];\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(awaited)) {
+			t.Errorf("got\n%s\nawaited the edge %q.", buf.Bytes(), awaited)
+		}
+	}
+}
+
+func TestParseColors(t *testing.T) {
+	colors, err := parseColors("T_=lightblue, R_=#ffffcc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := renderOptions{Colors: colors}
+	for name, color := range map[string]string{"T_A": "lightblue", "R_B": "#ffffcc", "X": ""} {
+		if got := opts.color(name); got != color {
+			t.Errorf("%s: got %q, awaited %q.", name, got, color)
+		}
+	}
+	for _, bad := range []string{"T_", "=red", "T_="} {
+		if _, err := parseColors(bad); err == nil {
+			t.Errorf("%q: awaited error.", bad)
+		}
+	}
+}
+
+func TestCommentLines(t *testing.T) {
+	for i, c := range []struct {
+		Options renderOptions
+		Comment string
+		Lines   []string
+	}{
+		{renderOptions{}, "", nil},
+		{renderOptions{}, " a\n b ", []string{"a b"}},
+		{renderOptions{CommentWidth: 5}, "abcde", []string{"abcde"}},
+		{renderOptions{CommentWidth: 5}, "abcdef", []string{"abcd…"}},
+		{renderOptions{CommentWidth: 5, CommentWrap: true}, "ab cd ef gh", []string{"ab cd", "ef gh"}},
+		{renderOptions{CommentWidth: 3, CommentWrap: true}, "a bcdefg h", []string{"a", "bcd", "efg", "h"}},
+		{renderOptions{CommentWidth: 4, CommentWrap: true}, "árvíz tűrő", []string{"árví", "z", "tűrő"}},
+	} {
+		got := c.Options.commentLines(c.Comment)
+		if !reflect.DeepEqual(got, c.Lines) {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c.Lines)
+		}
+	}
+}
+
+func TestEdgeWidth(t *testing.T) {
+	for count, width := range map[int]int{0: 1, 1: 1, 2: 2, 3: 2, 4: 3, 100: 7} {
+		if got := (edge{Count: count}).width(); got != width {
+			t.Errorf("%d: got %d, awaited %d.", count, got, width)
+		}
+	}
+}
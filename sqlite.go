@@ -0,0 +1,284 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	_ "github.com/mattn/go-sqlite3"
+	"gopkg.in/errgo.v1"
+)
+
+func init() {
+	providers["sqlite3"] = func(db *sql.DB, opts providerOptions) schemaProvider {
+		if len(opts.Schemas) != 0 {
+			glog.Warningf("sqlite3 does not support schemas, reading only the main database.")
+		}
+		return sqliteProvider{db}
+	}
+}
+
+// sqliteProvider reads the schema from sqlite_master and the table_info,
+// foreign_key_list and index_list pragmas. The sources are the triggers.
+//
+// SQLite names are case insensitive, so they are upper cased, as the parser does.
+type sqliteProvider struct {
+	db *sql.DB
+}
+
+func (p sqliteProvider) TableNames() (map[string]string, error) {
+	qry := `SELECT name FROM sqlite_master
+	      WHERE type = 'table' AND name NOT LIKE 'sqlite%'`
+	rows, err := p.db.Query(qry)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	tables := make(map[string]string, 128)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			glog.Warningf("error scanning table name: %v", err)
+			continue
+		}
+		glog.V(1).Infof("table %s", name)
+		tables[strings.ToUpper(name)] = "" // SQLite has no comments
+	}
+	if err := rows.Err(); err != nil && err != io.EOF {
+		return tables, errgo.Notef(err, "rows")
+	}
+	return tables, nil
+}
+
+func (p sqliteProvider) Tables() ([]table, error) {
+	tableNames, err := p.TableNames()
+	if err != nil {
+		return nil, errgo.Notef(err, "table names")
+	}
+	names := make([]string, 0, len(tableNames))
+	for name := range tableNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views, err := p.views()
+	if err != nil {
+		return nil, errgo.Notef(err, "views")
+	}
+
+	tables := make([]table, 0, len(names)+len(views))
+	for _, name := range names {
+		t := table{Name: name}
+		var pk constraint
+		if t.Fields, pk, err = p.tableFields(name); err != nil {
+			return tables, errgo.Notef(err, "fields of %q", name)
+		}
+		if len(pk.Fields) > 0 {
+			pk.Name = t.Name + "_PK"
+			t.Constraints = append(t.Constraints, pk)
+		}
+		uniques, err := p.uniques(name)
+		if err != nil {
+			return tables, errgo.Notef(err, "unique keys of %q", name)
+		}
+		t.Constraints = append(t.Constraints, uniques...)
+		foreigns, err := p.foreignKeys(name)
+		if err != nil {
+			return tables, errgo.Notef(err, "foreign keys of %q", name)
+		}
+		t.Constraints = append(t.Constraints, foreigns...)
+		tables = append(tables, t)
+	}
+	for _, t := range views {
+		if t.Fields, _, err = p.tableFields(t.Name); err != nil {
+			return tables, errgo.Notef(err, "fields of %q", t.Name)
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// views returns the views, with their CREATE VIEW statements, without the fields.
+func (p sqliteProvider) views() ([]table, error) {
+	qry := `SELECT name, sql FROM sqlite_master
+	      WHERE type = 'view' AND sql IS NOT NULL
+	      ORDER BY name`
+	rows, err := p.db.Query(qry)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	var views []table
+	for rows.Next() {
+		t := table{View: true}
+		if err = rows.Scan(&t.Name, &t.Text); err != nil {
+			glog.Warningf("error scanning view: %v", err)
+			continue
+		}
+		t.Name = strings.ToUpper(t.Name)
+		views = append(views, t)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return views, errgo.Mask(err)
+	}
+	return views, nil
+}
+
+// tableFields returns the fields and the primary key of the table.
+func (p sqliteProvider) tableFields(tbl string) ([]field, constraint, error) {
+	pk := constraint{Type: "P"}
+	qry := `SELECT name, type, pk FROM pragma_table_info(?) ORDER BY cid`
+	rows, err := p.db.Query(qry, tbl)
+	if err != nil {
+		return nil, pk, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	fields := make([]field, 0, 8)
+	var pkFields []string
+	for rows.Next() {
+		var f field
+		var pkPos int
+		if err = rows.Scan(&f.Name, &f.Type, &pkPos); err != nil {
+			glog.Warningf("error scanning field: %v", err)
+			continue
+		}
+		f.Name = strings.ToUpper(f.Name)
+		glog.V(1).Infof("tbl %s field %s", tbl, f.Name)
+		fields = append(fields, f)
+		if pkPos > 0 {
+			for len(pkFields) < pkPos {
+				pkFields = append(pkFields, "")
+			}
+			pkFields[pkPos-1] = f.Name
+		}
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return fields, pk, errgo.Mask(err)
+	}
+	pk.Fields = pkFields
+	return fields, pk, nil
+}
+
+// uniques returns the unique constraints of the table.
+func (p sqliteProvider) uniques(tbl string) ([]constraint, error) {
+	qry := `SELECT A.name, B.name
+	      FROM pragma_index_list(?) A, pragma_index_info(A.name) B
+	      WHERE A."unique" = 1 AND A.origin = 'u'
+	      ORDER BY A.name, B.seqno`
+	rows, err := p.db.Query(qry, tbl)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	var uniques []constraint
+	for rows.Next() {
+		var name, fld string
+		if err = rows.Scan(&name, &fld); err != nil {
+			glog.Warningf("error scanning unique key: %v", err)
+			continue
+		}
+		name = strings.ToUpper(name)
+		if len(uniques) == 0 || uniques[len(uniques)-1].Name != name {
+			uniques = append(uniques, constraint{Name: name, Type: "U"})
+		}
+		k := &uniques[len(uniques)-1]
+		k.Fields = append(k.Fields, strings.ToUpper(fld))
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return uniques, errgo.Mask(err)
+	}
+	return uniques, nil
+}
+
+// foreignKeys returns the foreign key constraints of the table.
+// The referenced columns default to the primary key of the referenced table,
+// as in "a_id INTEGER REFERENCES t_a".
+func (p sqliteProvider) foreignKeys(tbl string) ([]constraint, error) {
+	qry := `SELECT A.id, A."table", A."from",
+	             COALESCE(A."to", (SELECT B.name FROM pragma_table_info(A."table") B
+	                                 WHERE B.pk = A.seq + 1), '')
+	      FROM pragma_foreign_key_list(?) A
+	      ORDER BY A.id, A.seq`
+	rows, err := p.db.Query(qry, tbl)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	var foreigns []constraint
+	prev := -1
+	for rows.Next() {
+		var id int
+		var refTbl, fld, refFld string
+		if err = rows.Scan(&id, &refTbl, &fld, &refFld); err != nil {
+			glog.Warningf("error scanning foreign key: %v", err)
+			continue
+		}
+		if id != prev {
+			prev = id
+			foreigns = append(foreigns, constraint{
+				Name:     fmt.Sprintf("%s_FK%d", tbl, id),
+				Type:     "R",
+				RefTable: strings.ToUpper(refTbl),
+			})
+		}
+		k := &foreigns[len(foreigns)-1]
+		k.Fields = append(k.Fields, strings.ToUpper(fld))
+		k.RefFields = append(k.RefFields, strings.ToUpper(refFld))
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return foreigns, errgo.Mask(err)
+	}
+	valid := foreigns[:0]
+	for _, k := range foreigns {
+		if containsString(k.RefFields, "") {
+			glog.Warningf("%s: no referenced columns of %s.", k.Name, k.RefTable)
+			continue
+		}
+		valid = append(valid, k)
+	}
+	return valid, nil
+}
+
+func (p sqliteProvider) Sources() ([]source, error) {
+	qry := `SELECT name, type, sql FROM sqlite_master
+	      WHERE type = 'trigger' AND sql IS NOT NULL
+	      ORDER BY name`
+	rows, err := p.db.Query(qry)
+	if err != nil {
+		return nil, errgo.Notef(err, "query %q", qry)
+	}
+	defer rows.Close()
+	sources := make([]source, 0, 16)
+	for rows.Next() {
+		var s source
+		if err = rows.Scan(&s.Name, &s.Type, &s.Code); err != nil {
+			glog.Warningf("error scanning source: %v", err)
+			continue
+		}
+		s.Name, s.Type = strings.ToUpper(s.Name), strings.ToUpper(s.Type)
+		sources = append(sources, s)
+	}
+	if err = rows.Err(); err != nil && err != io.EOF {
+		return sources, errgo.Mask(err)
+	}
+	return sources, nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const sqliteSchema = `
+CREATE TABLE t_customer (id INTEGER PRIMARY KEY, name VARCHAR(100), email TEXT UNIQUE);
+CREATE TABLE t_contract (
+	id INTEGER, version INTEGER, customer_id INTEGER REFERENCES t_customer(id),
+	PRIMARY KEY (id, version));
+CREATE TABLE r_item (
+	contract_id INTEGER, contract_version INTEGER, amount NUMBER,
+	FOREIGN KEY (contract_id, contract_version) REFERENCES t_contract (id, version));
+CREATE TABLE r_note (
+	customer_id INTEGER REFERENCES t_customer, contract_id INTEGER, contract_version INTEGER,
+	FOREIGN KEY (contract_id, contract_version) REFERENCES t_contract);
+CREATE VIEW v_contract AS
+	SELECT c.id, u.name FROM t_contract c, t_customer u WHERE u.id = c.customer_id;
+CREATE TRIGGER db_item_ins AFTER INSERT ON r_item BEGIN
+	UPDATE t_contract SET version = version WHERE id = NEW.contract_id;
+END;
+`
+
+func openSqlite(t *testing.T) schemaProvider {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return providers["sqlite3"](db, providerOptions{})
+}
+
+func TestSqliteTables(t *testing.T) {
+	p := openSqlite(t)
+	tables, err := p.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	awaited := []table{
+		{Name: "R_ITEM",
+			Fields: []field{{"CONTRACT_ID", "INTEGER", ""}, {"CONTRACT_VERSION", "INTEGER", ""}, {"AMOUNT", "NUMBER", ""}},
+			Constraints: []constraint{
+				{Name: "R_ITEM_FK0", Type: "R", Fields: []string{"CONTRACT_ID", "CONTRACT_VERSION"},
+					RefTable: "T_CONTRACT", RefFields: []string{"ID", "VERSION"}},
+			}},
+		{Name: "R_NOTE",
+			Fields: []field{{"CUSTOMER_ID", "INTEGER", ""}, {"CONTRACT_ID", "INTEGER", ""}, {"CONTRACT_VERSION", "INTEGER", ""}},
+			Constraints: []constraint{
+				{Name: "R_NOTE_FK0", Type: "R", Fields: []string{"CONTRACT_ID", "CONTRACT_VERSION"},
+					RefTable: "T_CONTRACT", RefFields: []string{"ID", "VERSION"}},
+				{Name: "R_NOTE_FK1", Type: "R", Fields: []string{"CUSTOMER_ID"},
+					RefTable: "T_CUSTOMER", RefFields: []string{"ID"}},
+			}},
+		{Name: "T_CONTRACT",
+			Fields: []field{{"ID", "INTEGER", ""}, {"VERSION", "INTEGER", ""}, {"CUSTOMER_ID", "INTEGER", ""}},
+			Constraints: []constraint{
+				{Name: "T_CONTRACT_PK", Type: "P", Fields: []string{"ID", "VERSION"}},
+				{Name: "T_CONTRACT_FK0", Type: "R", Fields: []string{"CUSTOMER_ID"},
+					RefTable: "T_CUSTOMER", RefFields: []string{"ID"}},
+			}},
+		{Name: "T_CUSTOMER",
+			Fields: []field{{"ID", "INTEGER", ""}, {"NAME", "VARCHAR(100)", ""}, {"EMAIL", "TEXT", ""}},
+			Constraints: []constraint{
+				{Name: "T_CUSTOMER_PK", Type: "P", Fields: []string{"ID"}},
+				{Name: "SQLITE_AUTOINDEX_T_CUSTOMER_1", Type: "U", Fields: []string{"EMAIL"}},
+			}},
+		{Name: "V_CONTRACT", View: true,
+			Fields: []field{{"ID", "INTEGER", ""}, {"NAME", "VARCHAR(100)", ""}},
+			Text: `CREATE VIEW v_contract AS
+	SELECT c.id, u.name FROM t_contract c, t_customer u WHERE u.id = c.customer_id`},
+	}
+	if !reflect.DeepEqual(tables, awaited) {
+		t.Errorf("got\n%+v,\nawaited\n%+v", tables, awaited)
+	}
+}
+
+func TestSqliteSources(t *testing.T) {
+	p := openSqlite(t)
+	sources, err := p.Sources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("got %d sources, awaited 1: %+v", len(sources), sources)
+	}
+	if sources[0].Name != "DB_ITEM_INS" || sources[0].Type != "TRIGGER" {
+		t.Errorf("got %s %s, awaited TRIGGER DB_ITEM_INS.", sources[0].Type, sources[0].Name)
+	}
+}
+
+func TestSqliteViewLinks(t *testing.T) {
+	p := openSqlite(t)
+	tables, err := p.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := analyze(tables, nil, analyzeOptions{})
+	var got []string
+	for _, e := range g.Edges {
+		got = append(got, fmt.Sprintf("%d %s.%v-%s.%v", e.Kind, e.A.Table, e.A.Fields, e.B.Table, e.B.Fields))
+	}
+	awaited := []string{
+		"1 R_ITEM.[CONTRACT_ID CONTRACT_VERSION]-T_CONTRACT.[ID VERSION]",
+		"1 R_NOTE.[CONTRACT_ID CONTRACT_VERSION]-T_CONTRACT.[ID VERSION]",
+		"1 R_NOTE.[CUSTOMER_ID]-T_CUSTOMER.[ID]",
+		"1 T_CONTRACT.[CUSTOMER_ID]-T_CUSTOMER.[ID]",
+		"2 V_CONTRACT.[ID]-T_CONTRACT.[ID]",
+		"2 V_CONTRACT.[NAME]-T_CUSTOMER.[NAME]",
+	}
+	if !reflect.DeepEqual(got, awaited) {
+		t.Errorf("got\n%q,\nawaited\n%q", got, awaited)
+	}
+}
@@ -0,0 +1,462 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// statement is a SELECT, INSERT, UPDATE, DELETE or MERGE statement.
+type statement struct {
+	Kind    string     // SELECT, INSERT, UPDATE, DELETE or MERGE
+	Target  []tableRef // the written tables (INSERT ALL may have several)
+	Ops     string     // the operations on the targets: some of C, U and D
+	Queries []*query   // the queries, with the equations of the joins
+}
+
+// dmlKeywords start a data manipulation statement.
+var dmlKeywords = map[string]bool{"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true}
+
+// getStatements returns the SELECT, INSERT, UPDATE, DELETE and MERGE statements
+// from the code, with the comments stripped.
+//
+// The queries embedded in PL/SQL are found in every form:
+// CURSOR c [(params)] [RETURN type] IS query; FOR rec IN (query) LOOP;
+// OPEN c FOR query; SELECT ... [BULK COLLECT] INTO ... FROM;
+// and DML statements after FORALL.
+func getStatements(code string) []string {
+	stmts, _ := locateStatements(code)
+	return stmts
+}
+
+// locateStatements returns the statements as getStatements does,
+// and the lines they start at.
+func locateStatements(code string) ([]string, []int) {
+	stripped := stripComments(code)
+	toks := significant(lex(code))
+	stmts, lines := make([]string, 0, 4), make([]int, 0, 4)
+	for i := 0; i < len(toks); i++ {
+		if !(toks[i].Kind == tokKeyword && toks[i].is("SELECT") || isWithStart(toks, i) || isDMLStart(toks, i)) {
+			continue
+		}
+		end := statementEnd(toks, i)
+		endPos := len(code)
+		if end < len(toks) {
+			endPos = toks[end].Pos
+		} else if end > 0 {
+			endPos = toks[end-1].End
+		}
+		stmts, lines = append(stmts, stripped[toks[i].Pos:endPos]), append(lines, toks[i].Line)
+		i = end
+	}
+	return stmts, lines
+}
+
+// statementEnd returns the index of the token ending the statement starting at toks[i]:
+// the closing ";", an unmatched ")", or len(toks).
+func statementEnd(toks []token, i int) int {
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		switch {
+		case toks[j].is("("):
+			depth++
+		case toks[j].is(")"):
+			if depth--; depth < 0 {
+				return j
+			}
+		case toks[j].is(";") && depth == 0:
+			return j
+		}
+	}
+	return len(toks)
+}
+
+// stripComments strips the comments from the PL/SQL code
+func stripComments(code string) string {
+	var buf []byte
+	for _, t := range lex(code) {
+		if t.Kind != tokComment {
+			continue
+		}
+		if buf == nil {
+			buf = []byte(code)
+		}
+		for i := t.Pos; i < t.End; i++ {
+			if buf[i] != '\n' {
+				buf[i] = ' '
+			}
+		}
+	}
+	if buf == nil {
+		return code
+	}
+	return string(buf)
+}
+
+// getDynamicStatements returns the statements built as strings for
+// EXECUTE IMMEDIATE and OPEN cursor FOR, and the lines of those.
+// The string literals are concatenated, following the assignments
+// of the variables (regardless of the control flow);
+// the other operands are replaced by their text.
+func getDynamicStatements(code string) ([]string, []int) {
+	toks := significant(lex(code))
+	vars := make(map[string]string)
+	var stmts []string
+	var lines []int
+	for i := 0; i < len(toks); i++ {
+		var start int
+		switch {
+		case toks[i].is("EXECUTE") && i+1 < len(toks) && toks[i+1].is("IMMEDIATE"):
+			start = i + 2
+		case toks[i].is("OPEN") && i+3 < len(toks) && toks[i+1].isName() && toks[i+2].is("FOR") &&
+			!(toks[i+3].is("SELECT") || toks[i+3].is("WITH")):
+			start = i + 3
+		case toks[i].isName() && i+1 < len(toks) && toks[i+1].is(":=") && (i == 0 || !toks[i-1].is(".")):
+			end := dynamicEnd(toks, i+2)
+			if text, ok := stringValue(toks[i+2:end], vars); ok {
+				vars[toks[i].name()] = text
+			} else {
+				delete(vars, toks[i].name())
+			}
+			i = end
+			continue
+		default:
+			continue
+		}
+		end := dynamicEnd(toks, start)
+		if text, ok := stringValue(toks[start:end], vars); ok {
+			glog.V(2).Infof("dynamic SQL: %q", text)
+			for _, st := range getStatements(text) {
+				stmts, lines = append(stmts, st), append(lines, toks[i].Line)
+			}
+		}
+		i = end
+	}
+	return stmts, lines
+}
+
+// dynamicEnd returns the end of the string expression starting at toks[i]:
+// the index of the first top level ";", INTO, USING or RETURNING.
+func dynamicEnd(toks []token, i int) int {
+	depth := 0
+	for ; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth == 0 && (t.is(";") || t.Kind == tokKeyword && (t.is("INTO") || t.is("USING") || t.is("RETURNING"))):
+			return i
+		}
+	}
+	return i
+}
+
+// stringValue returns the value of the a || b || ... string expression,
+// and whether it contains any string literal or string variable.
+func stringValue(toks []token, vars map[string]string) (string, bool) {
+	var buf strings.Builder
+	found := false
+	for len(toks) != 0 {
+		end, depth := 0, 0
+		for ; end < len(toks) && !(depth == 0 && toks[end].is("||")); end++ {
+			if toks[end].is("(") {
+				depth++
+			} else if toks[end].is(")") {
+				depth--
+			}
+		}
+		operand := toks[:end]
+		if end < len(toks) {
+			end++
+		}
+		toks = toks[end:]
+		if len(operand) == 1 && operand[0].Kind == tokString {
+			buf.WriteString(stringLiteral(operand[0].Text))
+			found = true
+			continue
+		}
+		if len(operand) == 1 && operand[0].isName() {
+			if text, ok := vars[operand[0].name()]; ok {
+				buf.WriteString(text)
+				found = true
+				continue
+			}
+		}
+		if len(operand) > 2 && operand[0].is("(") && matchingBracket(operand, 0) == len(operand)-1 {
+			if text, ok := stringValue(operand[1:len(operand)-1], vars); ok {
+				buf.WriteString(text)
+				found = true
+				continue
+			}
+		}
+		for j, t := range operand {
+			if j > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(t.Text)
+		}
+	}
+	return buf.String(), found
+}
+
+// stringLiteral returns the value of the 'abc', q'[abc]' or N'abc' literal.
+func stringLiteral(text string) string {
+	if len(text) != 0 && (text[0] == 'n' || text[0] == 'N') {
+		text = text[1:]
+	}
+	if len(text) != 0 && (text[0] == 'q' || text[0] == 'Q') {
+		if len(text) < 5 {
+			return ""
+		}
+		return text[3 : len(text)-2]
+	}
+	if len(text) < 2 {
+		return ""
+	}
+	return strings.Replace(text[1:len(text)-1], "''", "'", -1)
+}
+
+// isWithStart reports whether toks[i] starts a WITH name [(columns)] AS (subquery) clause.
+func isWithStart(toks []token, i int) bool {
+	return toks[i].Kind == tokKeyword && toks[i].is("WITH") &&
+		i+2 < len(toks) && toks[i+1].isName() && (toks[i+2].is("AS") || toks[i+2].is("("))
+}
+
+// isDMLStart reports whether toks[i] starts a DML statement,
+// and is not a collection method (v.DELETE), a FOR UPDATE clause,
+// or a trigger event (BEFORE INSERT OR UPDATE OF x ON t).
+func isDMLStart(toks []token, i int) bool {
+	t := toks[i]
+	if !(t.Kind == tokKeyword && dmlKeywords[strings.ToUpper(t.Text)]) {
+		return false
+	}
+	if i > 0 && (toks[i-1].is(".") || toks[i-1].is("FOR") || toks[i-1].is("OR")) {
+		return false
+	}
+	if i+1 >= len(toks) {
+		return false
+	}
+	next := toks[i+1]
+	switch strings.ToUpper(t.Text) {
+	case "INSERT":
+		return next.is("INTO") || next.is("ALL") || next.is("FIRST")
+	case "MERGE":
+		return next.is("INTO")
+	case "DELETE":
+		return next.is("FROM") || next.isName()
+	}
+	// UPDATE
+	return next.isName()
+}
+
+// parseStatement parses the tokens of a statement.
+func parseStatement(toks []token) statement {
+	if len(toks) == 0 {
+		return statement{}
+	}
+	st := statement{Kind: strings.ToUpper(toks[0].Text)}
+	switch st.Kind {
+	case "SELECT", "WITH":
+		st.Kind = "SELECT"
+		if q := parseQuery(toks); q != nil {
+			st.Queries = append(st.Queries, q)
+		}
+		return st
+	case "INSERT":
+		st.Ops = "C"
+		parseInsert(&st, toks)
+		return st
+	case "MERGE":
+		parseMerge(&st, toks)
+		return st
+	}
+
+	// UPDATE t [alias] SET ... [WHERE ...] and DELETE [FROM] t [alias] [WHERE ...]
+	st.Ops = st.Kind[:1]
+	i := 1
+	if i < len(toks) && toks[i].is("FROM") {
+		i++
+	}
+	end := indexTopLevel(toks[i:], func(t token) bool {
+		return t.Kind == tokKeyword && (t.is("SET") || t.is("WHERE") || t.is("RETURNING"))
+	})
+	if end < 0 {
+		end = len(toks) - i
+	}
+	target, ok := parseTableRef(toks[i:i+end], scope{})
+	if !ok {
+		glog.V(1).Infof("cannot find the target of %v", toks)
+		return st
+	}
+	st.Target = append(st.Target, target)
+	q := &query{Tables: st.Target}
+	if w := indexTopLevel(toks, func(t token) bool { return t.is("WHERE") }); w >= 0 {
+		cond := toks[w+1:]
+		if r := indexTopLevel(cond, func(t token) bool { return t.is("RETURNING") }); r >= 0 {
+			cond = cond[:r]
+		}
+		q.Equations = equations(withoutSubqueries(cond))
+	}
+	q.Sub = subqueries(toks[i+end:], scope{}.inner(q))
+	st.Queries = append(st.Queries, q)
+	return st
+}
+
+// parseInsert parses an INSERT [ALL|FIRST] INTO t [(columns)] {VALUES (...) | SELECT ...}
+// statement: every INTO at the top level is a target, up to a RETURNING ... INTO variables.
+func parseInsert(st *statement, toks []token) {
+	if r := indexTopLevel(toks, func(t token) bool { return t.is("RETURNING") }); r >= 0 {
+		toks = toks[:r]
+	}
+	sel := len(toks)
+	for i := 1; i < len(toks); i++ {
+		switch {
+		case toks[i].is("("):
+			if j := matchingBracket(toks, i); j > 0 {
+				i = j
+			}
+		case toks[i].is("INTO"):
+			j := i + 1
+			for j < len(toks) && (toks[j].isName() || toks[j].is(".")) {
+				j++
+			}
+			if target, ok := parseTableRef(toks[i+1:j], scope{}); ok {
+				st.Target = append(st.Target, target)
+			}
+			i = j - 1
+		case toks[i].is("SELECT") || toks[i].is("WITH"):
+			sel = i
+		}
+		if sel < len(toks) {
+			break
+		}
+	}
+	if sel < len(toks) {
+		if q := parseQuery(toks[sel:]); q != nil {
+			st.Queries = append(st.Queries, q)
+		}
+	}
+	st.Queries = append(st.Queries, subqueries(toks[:sel], scope{})...)
+}
+
+// parseMerge parses a MERGE INTO t [alias] USING {s | (subquery)} [alias] ON (cond)
+// WHEN [NOT] MATCHED THEN ... statement.
+func parseMerge(st *statement, toks []token) {
+	using := indexTopLevel(toks, func(t token) bool { return t.is("USING") })
+	on := indexTopLevel(toks, func(t token) bool { return t.is("ON") })
+	if using < 2 || on < using {
+		glog.V(1).Infof("cannot parse MERGE %v", toks)
+		return
+	}
+	target, ok := parseTableRef(toks[2:using], scope{})
+	if !ok {
+		return
+	}
+	st.Target = append(st.Target, target)
+	q := &query{Tables: st.Target}
+	if src, ok := parseTableRef(toks[using+1:on], scope{}); ok {
+		q.Tables = append(q.Tables[:len(q.Tables):len(q.Tables)], src)
+	}
+	cond := toks[on+1:]
+	if len(cond) > 0 && cond[0].is("(") {
+		if j := matchingBracket(cond, 0); j > 0 {
+			cond = cond[1:j]
+		}
+	}
+	q.Equations = equations(withoutSubqueries(cond))
+	q.Sub = subqueries(toks[on+1:], scope{}.inner(q))
+	st.Queries = append(st.Queries, q)
+
+	// the operations of the WHEN clauses
+	update := false // in a WHEN MATCHED THEN UPDATE clause
+	for i := on + 1; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case t.is("("):
+			if j := matchingBracket(toks, i); j > 0 {
+				i = j
+			}
+		case t.is("WHEN") && i+1 < len(toks) && (toks[i+1].is("MATCHED") || toks[i+1].is("NOT")):
+			update = false
+		case t.is("THEN") && i+1 < len(toks):
+			switch next := toks[i+1]; {
+			case next.is("UPDATE"):
+				st.Ops, update = addOp(st.Ops, "U"), true
+			case next.is("INSERT"):
+				st.Ops = addOp(st.Ops, "C")
+			case next.is("DELETE"):
+				st.Ops = addOp(st.Ops, "D")
+			}
+		case t.is("DELETE") && update && i+1 < len(toks) && toks[i+1].is("WHERE"):
+			st.Ops = addOp(st.Ops, "D") // ... UPDATE SET ... DELETE WHERE ...
+		}
+	}
+}
+
+// addOp adds the operation to ops, keeping the CRUD order.
+func addOp(ops, op string) string {
+	if strings.Contains(ops, op) {
+		return ops
+	}
+	var b strings.Builder
+	for _, c := range "CRUD" {
+		if strings.ContainsRune(ops, c) || string(c) == op {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// links returns the links of the statement.
+func (st statement) links() []link {
+	var links []link
+	for _, q := range st.Queries {
+		q.walk(func(q *query) {
+			links = append(links, q.links()...)
+		})
+	}
+	return links
+}
+
+// reads returns the tables read by the statement: the tables of its queries
+// and subqueries, without the targets (unless a subquery reads them).
+func (st statement) reads() []tableRef {
+	var refs []tableRef
+	for i, q := range st.Queries {
+		top := q
+		q.walk(func(q *query) {
+			for _, t := range q.Tables {
+				if t.Name == "" || i == 0 && q == top && st.Kind != "SELECT" && st.Kind != "INSERT" && isTarget(st.Target, t) {
+					continue
+				}
+				refs = append(refs, t)
+			}
+		})
+	}
+	return refs
+}
+
+func isTarget(targets []tableRef, t tableRef) bool {
+	for _, target := range targets {
+		if target.Owner == t.Owner && target.Name == t.Name && target.Alias == t.Alias {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var rSpaces = regexp.MustCompile("[ \t\n]+")
+
+func TestGetStatements(t *testing.T) {
+	code := `
+CREATE TRIGGER trg BEFORE INSERT OR UPDATE OF x OR DELETE ON t_a FOR EACH ROW
+BEGIN
+  v_tab.DELETE;
+  SELECT 1 INTO v_x FROM t_b WHERE id = :NEW.id FOR UPDATE;
+  UPDATE t_b SET x = 1 WHERE id = :NEW.id;
+  IF v_x THEN DELETE t_c WHERE id = 1; END IF;
+  INSERT INTO t_d (id) VALUES (1);
+  MERGE INTO t_e USING t_f ON (t_e.id = t_f.id) WHEN MATCHED THEN UPDATE SET x = 1;
+  WITH w AS (SELECT id FROM t_g) SELECT COUNT(*) INTO v_x FROM w;
+END;`
+	var got []string
+	for _, st := range getStatements(code) {
+		got = append(got, strings.Fields(st)[0])
+	}
+	if awaited := "SELECT UPDATE DELETE INSERT MERGE WITH"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", got, awaited)
+	}
+}
+
+func TestGetStatementsText(t *testing.T) {
+	for i, c := range []struct {
+		Code       string
+		Statements []string
+	}{
+		{"aaa", nil},
+		{"aaa--SELECT;", nil},
+		{"aa/*SELECT;*/bb", nil},
+		{`aa/*SELECT
+		;*/sds`, nil},
+		{"dasd SELECT f; sdasd", []string{"SELECT f"}},
+		{`dassd SELECT --
+		from a /*
+		sdasd;*/
+		WHERE a=';'
+		--;
+		;aaa`, []string{`SELECT
+		from a
+
+		WHERE a=';'
+
+		`},
+		},
+		{"FOR sor IN (SELECT A FROM (SELECT B))", []string{"SELECT A FROM (SELECT B)"}},
+		{"IF x THEN DELETE t WHERE id = 1; END IF; v_tab.DELETE;", []string{"DELETE t WHERE id = 1"}},
+		{"x := 'it''s; SELECT'; SELECT q'[;)]' FROM dual; y", []string{"SELECT q'[;)]' FROM dual"}},
+		{`SELECT "a;b" FROM t /* ; */ WHERE c = '--';`, []string{`SELECT "a;b" FROM t         WHERE c = '--'`}},
+	} {
+		got := getStatements(c.Code)
+		if len(got) != len(c.Statements) {
+			t.Errorf("%d. count mistmatch: got %d, awaited %d (%q).", i, len(got), len(c.Statements), c.Code)
+			continue
+		}
+		if len(got) == 0 {
+			continue
+		}
+		for j, txt := range got {
+			if txt != c.Statements[j] && stripSpaces(txt) != stripSpaces(c.Statements[j]) {
+				t.Errorf("%d. %d: got %q, awaited %q.", i, j, stripSpaces(txt), stripSpaces(c.Statements[j]))
+			}
+		}
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	for i, c := range [][2]string{
+		{"aaa", "aaa"},
+		{"aaa--SELECT;", "aaa         "},
+		{"aa/*SELECT;*/bb", "aa           bb"},
+		{`aa/*SELECT
+		;*/sds`, `aa      sds`},
+		{`dassd SELECT --
+		from a /*
+		sdasd;*/
+		WHERE a=';'
+		--;
+		;aaa`, `dassd SELECT
+		from a
+
+		WHERE a=';'
+
+		;aaa`},
+	} {
+		got := stripComments(c[0])
+		if got != c[1] && stripSpaces(got) != stripSpaces(c[1]) {
+			t.Errorf("%d. got %q, awaited %q.", i, stripSpaces(got), stripSpaces(c[1]))
+		}
+	}
+}
+
+func TestStatementEnd(t *testing.T) {
+	for i, c := range []struct {
+		Text string
+		Pos  int
+	}{
+		{"abraka", -1},
+		{"aaa;sdd", 3},
+		{"aa';';", 5},
+		{"a (b; c); d", 8},
+		{"aaa)sdd", 3},
+		{"aa(')')b)", 8},
+	} {
+		toks := significant(lex(c.Text))
+		got := -1
+		if end := statementEnd(toks, 0); end < len(toks) {
+			got = toks[end].Pos
+		}
+		if got != c.Pos {
+			t.Errorf("%d. got %d, awaited %d (%q)", i, got, c.Pos, c.Text)
+		}
+	}
+}
+
+func TestGetDynamicStatements(t *testing.T) {
+	for i, c := range []struct {
+		Code, Awaited string
+	}{
+		{`EXECUTE IMMEDIATE 'SELECT 1 FROM a, b WHERE a.x = b.y' INTO v_x;`,
+			"SELECT 1 FROM a, b WHERE a.x = b.y"},
+		{`EXECUTE IMMEDIATE 'DELETE FROM ' || v_tab || ' WHERE id = :1' USING p_id;`,
+			"DELETE FROM v_tab WHERE id = :1"},
+		{`v_sql := q'[SELECT 1 FROM a WHERE a.name = 'x']';
+		  v_sql := v_sql || ' AND a.id = ' || TO_CHAR(p_id);
+		  OPEN c FOR v_sql;`,
+			"SELECT 1 FROM a WHERE a.name = 'x' AND a.id = TO_CHAR ( p_id )"},
+		{`OPEN c FOR ('SELECT 1 ' || 'FROM a');`, "SELECT 1 FROM a"},
+		{`OPEN c FOR SELECT 1 FROM a; EXECUTE IMMEDIATE v_unknown;`, ""},
+		{`EXECUTE IMMEDIATE 'BEGIN UPDATE a SET x = 1; END;';`, "UPDATE a SET x = 1"},
+	} {
+		stmts, _ := getDynamicStatements(c.Code)
+		got := strings.Join(stmts, "|")
+		if got != c.Awaited {
+			t.Errorf("%d. got %q, awaited %q.", i, got, c.Awaited)
+		}
+	}
+}
+
+func TestParseStatement(t *testing.T) {
+	for i, c := range []struct {
+		Code                     string
+		Kind, Target, Ops, Reads string
+		Links                    string
+	}{
+		{"SELECT 1 FROM a, b WHERE a.x = b.y", "SELECT", "", "", "A B", "A.X=B.Y"},
+		{"UPDATE t_a A SET x = (SELECT MAX(y) FROM t_b B WHERE B.a_id = A.id) WHERE A.z = 1",
+			"UPDATE", "T_A", "U", "T_B", "T_A.ID=T_B.A_ID"},
+		{"UPDATE t_a SET x = 1 WHERE t_a.y = (SELECT y FROM t_b WHERE t_b.id = 1)",
+			"UPDATE", "T_A", "U", "T_B", ""},
+		{"DELETE FROM t_a A WHERE EXISTS (SELECT 1 FROM t_b B WHERE B.a_id = A.id)",
+			"DELETE", "T_A", "D", "T_B", "T_A.ID=T_B.A_ID"},
+		{"DELETE t_a WHERE id = 1 RETURNING x INTO v_x", "DELETE", "T_A", "D", "", ""},
+		{"INSERT INTO t_a (x, y) SELECT b.x, c.y FROM t_b b, t_c c WHERE c.b_id = b.id",
+			"INSERT", "T_A", "C", "T_B T_C", "T_B.ID=T_C.B_ID"},
+		{"INSERT INTO t_a VALUES ((SELECT x FROM t_b), 1)", "INSERT", "T_A", "C", "T_B", ""},
+		{"INSERT INTO t_a (x, y) VALUES (1, 2) RETURNING id INTO l_id", "INSERT", "T_A", "C", "", ""},
+		{"INSERT ALL INTO t_a VALUES (1) INTO t_b VALUES (2) SELECT * FROM dual",
+			"INSERT", "T_A T_B", "C", "DUAL", ""},
+		{`MERGE INTO t_a A USING t_b B ON (A.id = B.id AND A.k = B.k)
+		  WHEN MATCHED THEN UPDATE SET A.x = B.x DELETE WHERE A.x IS NULL
+		  WHEN NOT MATCHED THEN INSERT (id, x) VALUES (B.id, B.x)`,
+			"MERGE", "T_A", "CUD", "T_B", "T_A.ID,K=T_B.ID,K"},
+		{"MERGE INTO t_a A USING t_b B ON (A.id = B.id) WHEN MATCHED THEN UPDATE SET A.x = 5 DELETE WHERE A.y = 0",
+			"MERGE", "T_A", "UD", "T_B", "T_A.ID=T_B.ID"},
+		{"MERGE INTO t_a A USING t_b B ON (A.id = B.id) WHEN MATCHED THEN UPDATE SET A.x = f(B.y) DELETE WHERE A.y = 0",
+			"MERGE", "T_A", "UD", "T_B", "T_A.ID=T_B.ID"},
+		{`MERGE INTO t_a A USING t_b B ON (A.id = B.id)
+		  WHEN MATCHED THEN UPDATE SET A.x = CASE WHEN B.y > 0 THEN 1 ELSE 0 END DELETE WHERE A.x = 0`,
+			"MERGE", "T_A", "UD", "T_B", "T_A.ID=T_B.ID"},
+		{"MERGE INTO t_a A USING (SELECT c.id FROM t_c c, t_d d WHERE d.c_id = c.id) S ON (A.id = S.id) WHEN MATCHED THEN UPDATE SET A.x = 1",
+			"MERGE", "T_A", "U", "T_C T_D", "T_A.ID=T_C.ID T_C.ID=T_D.C_ID"},
+	} {
+		st := parseStatement(significant(lex(c.Code)))
+		var targets, reads, links []string
+		for _, ref := range st.Target {
+			targets = append(targets, ref.Name)
+		}
+		for _, ref := range st.reads() {
+			reads = append(reads, ref.Name)
+		}
+		for _, lnk := range st.links() {
+			links = append(links, lnk.A.Table+"."+strings.Join(lnk.A.Fields, ",")+"="+
+				lnk.B.Table+"."+strings.Join(lnk.B.Fields, ","))
+		}
+		for _, x := range [][3]string{
+			{"kind", st.Kind, c.Kind}, {"target", strings.Join(targets, " "), c.Target},
+			{"ops", st.Ops, c.Ops}, {"reads", strings.Join(reads, " "), c.Reads},
+			{"links", strings.Join(links, " "), c.Links},
+		} {
+			if x[1] != x[2] {
+				t.Errorf("%d. %s: got %q, awaited %q.", i, x[0], x[1], x[2])
+			}
+		}
+	}
+}
+
+func stripSpaces(text string) string {
+	return rSpaces.ReplaceAllString(text, " ")
+}
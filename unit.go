@@ -0,0 +1,120 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "strings"
+
+// sourceUnit is a procedure or function of a package (or type) body,
+// with its first and last lines.
+type sourceUnit struct {
+	Name       string // PKG.PROC
+	Start, End int    // 1-based lines, inclusive
+}
+
+// units returns the top level procedures and functions of a package or type body.
+// The local subprograms are part of their enclosing unit, and the other
+// source types (standalone procedures, triggers, specs) are not split.
+func (s source) units() []sourceUnit {
+	if !(s.Type == "PACKAGE BODY" || s.Type == "TYPE BODY") {
+		return nil
+	}
+	toks := significant(lex(s.Code))
+	// frame is a subprogram, waiting for the END of its body
+	type frame struct {
+		started bool // after the BEGIN of the body
+		depth   int  // BEGIN and CASE blocks
+	}
+	var stack []frame
+	var units []sourceUnit
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.Kind == tokKeyword && (t.is("PROCEDURE") || t.is("FUNCTION")) &&
+			i+1 < len(toks) && toks[i+1].isName() && isSubprogramBody(toks, i+2):
+			if len(stack) == 0 {
+				units = append(units, sourceUnit{Name: s.Name + "." + toks[i+1].name(), Start: t.Line})
+			}
+			stack = append(stack, frame{})
+		case len(stack) == 0:
+		case t.is("BEGIN"):
+			top := &stack[len(stack)-1]
+			top.started = true
+			top.depth++
+		case t.is("CASE") && stack[len(stack)-1].started:
+			stack[len(stack)-1].depth++
+		case t.is("END"):
+			if i+1 < len(toks) && (toks[i+1].is("IF") || toks[i+1].is("LOOP")) {
+				i++
+				continue
+			}
+			if i+1 < len(toks) && toks[i+1].is("CASE") {
+				i++
+			}
+			top := &stack[len(stack)-1]
+			if !top.started {
+				continue
+			}
+			if top.depth--; top.depth > 0 {
+				continue
+			}
+			if stack = stack[:len(stack)-1]; len(stack) == 0 {
+				units[len(units)-1].End = t.Line
+				for j := i + 1; j < len(toks); j++ {
+					if toks[j].is(";") {
+						units[len(units)-1].End = toks[j].Line
+						break
+					}
+				}
+			}
+		}
+	}
+	if len(units) != 0 && units[len(units)-1].End == 0 {
+		units[len(units)-1].End = strings.Count(s.Code, "\n") + 1
+	}
+	return units
+}
+
+// isSubprogramBody reports whether the PROCEDURE or FUNCTION header,
+// continuing at toks[i], has a body (IS or AS), and is not a declaration.
+func isSubprogramBody(toks []token, i int) bool {
+	depth := 0
+	for ; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case t.is("("):
+			depth++
+		case t.is(")"):
+			depth--
+		case depth != 0:
+		case t.is(";"):
+			return false
+		case t.Kind == tokKeyword && (t.is("IS") || t.is("AS")):
+			return true
+		}
+	}
+	return false
+}
+
+// unitAt returns the name of the unit containing the line,
+// or the name of the source, if the line is outside of the units.
+func (s source) unitAt(units []sourceUnit, line int) string {
+	for _, u := range units {
+		if u.Start <= line && line <= u.End {
+			return u.Name
+		}
+	}
+	return s.Name
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnits(t *testing.T) {
+	code := `PACKAGE BODY DB_X IS
+g_x NUMBER;
+PROCEDURE fwd(p_id IN NUMBER);
+FUNCTION calc(p_id IN NUMBER) RETURN NUMBER IS
+  PROCEDURE local IS
+  BEGIN
+    NULL;
+  END local;
+BEGIN
+  CASE WHEN p_id = 1 THEN local; END CASE;
+  IF p_id > 1 THEN
+    FOR i IN 1..p_id LOOP NULL; END LOOP;
+  END IF;
+  RETURN CASE p_id WHEN 0 THEN 0 ELSE 1 END;
+END calc;
+PROCEDURE fwd(p_id IN NUMBER) AS
+BEGIN
+  BEGIN
+    NULL;
+  EXCEPTION WHEN OTHERS THEN NULL;
+  END;
+END
+  fwd;
+BEGIN
+  g_x := 0;
+END DB_X;`
+	s := source{Name: "DB_X", Type: "PACKAGE BODY", Code: code}
+	units := s.units()
+	var got []string
+	for _, u := range units {
+		got = append(got, fmt.Sprintf("%s:%d-%d", u.Name, u.Start, u.End))
+	}
+	if awaited := "DB_X.CALC:4-15 DB_X.FWD:16-23"; strings.Join(got, " ") != awaited {
+		t.Errorf("got %q, awaited %q.", got, awaited)
+	}
+	for i, c := range []struct {
+		Line    int
+		Awaited string
+	}{
+		{2, "DB_X"}, {7, "DB_X.CALC"}, {15, "DB_X.CALC"}, {20, "DB_X.FWD"}, {25, "DB_X"},
+	} {
+		if got := s.unitAt(units, c.Line); got != c.Awaited {
+			t.Errorf("%d. line %d: got %q, awaited %q.", i, c.Line, got, c.Awaited)
+		}
+	}
+
+	s.Type = "PACKAGE"
+	if units := s.units(); len(units) != 0 {
+		t.Errorf("got %v, awaited no units for a spec.", units)
+	}
+}
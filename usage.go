@@ -0,0 +1,74 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "strings"
+
+// accessNames are the names of the operations of tableUsage.Ops.
+var accessNames = map[rune]string{'C': "insert", 'R': "select", 'U': "update", 'D': "delete"}
+
+// newUsageGraph returns the bipartite graph of the source objects and the tables
+// they access, with the edges labelled with the kinds of the access.
+// The units of a package are merged into the package.
+func newUsageGraph(usage []sourceUsage, tables []table) *depGraph {
+	var nodes []depNode
+	seen := make(map[string]bool)
+	addNode := func(key string, n depNode) {
+		if !seen[key] {
+			seen[key] = true
+			nodes = append(nodes, n)
+		}
+	}
+	known := make(map[string]table, len(tables))
+	for _, t := range tables {
+		known[qualifiedName(t.Owner, t.Name)] = t
+	}
+	var edges [][2]string
+	ops := make(map[[2]string]string)
+	for _, su := range usage {
+		object := qualifiedName(su.Owner, su.Name)
+		addNode(object, depNode{Owner: su.Owner, Name: su.Name, Kind: strings.TrimSuffix(su.Type, " BODY")})
+		for _, tu := range su.Tables {
+			t, ok := known[tu.Table]
+			if !ok {
+				continue
+			}
+			kind := "TABLE"
+			if t.View {
+				kind = "VIEW"
+			}
+			addNode(tu.Table, depNode{Owner: t.Owner, Name: t.Name, Kind: kind})
+			key := [2]string{object, tu.Table}
+			if _, ok := ops[key]; !ok {
+				edges = append(edges, key)
+			}
+			for _, op := range tu.Ops {
+				ops[key] = addOp(ops[key], string(op))
+			}
+		}
+	}
+	g := newDepGraph(nodes, edges)
+	for i, e := range g.Edges {
+		from, to := g.Nodes[e.From], g.Nodes[e.To]
+		var names []string
+		for _, op := range ops[[2]string{qualifiedName(from.Owner, from.Name), qualifiedName(to.Owner, to.Name)}] {
+			names = append(names, accessNames[op])
+		}
+		g.Edges[i].Label = strings.Join(names, ", ")
+	}
+	return g
+}
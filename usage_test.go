@@ -0,0 +1,47 @@
+/*
+Copyright 2014 Tamás Gulácsi
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUsageGraphGolden(t *testing.T) {
+	sources := append([]source{{Name: "DB_REMOVE", Type: "PACKAGE BODY", Code: `
+PACKAGE BODY DB_REMOVE IS
+PROCEDURE del(p_id IN NUMBER) IS
+BEGIN
+  DELETE FROM r_item WHERE contract_id = p_id;
+  UPDATE t_contract C SET amount = 0 WHERE C.id = p_id;
+END del;
+PROCEDURE copy(p_id IN NUMBER) IS
+BEGIN
+  INSERT INTO r_item (contract_id, product) SELECT id, 'X' FROM v_contract WHERE id = p_id;
+END copy;
+END DB_REMOVE;
+`}}, testSources...)
+	g := newUsageGraph(analyze(viewTables, sources, analyzeOptions{}).Usage, viewTables)
+	for _, format := range rendererNames() {
+		var buf bytes.Buffer
+		if err := renderers[format](renderOptions{}).RenderDeps(&buf, g); err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		checkGolden(t, "usage."+format, buf.Bytes())
+	}
+}